@@ -0,0 +1,361 @@
+// Package asar reads and writes Electron asar archives.
+package asar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const BLOCK_SIZE = 4 * 1024 * 1024
+
+var ErrNotFound = errors.New("asar: entry not found")
+
+type Integrity struct {
+	Algorithm string   `json:"algorithm"`
+	Hash      string   `json:"hash"`
+	BlockSize int      `json:"blockSize"`
+	Blocks    []string `json:"blocks"`
+}
+
+type Entry struct {
+	Size       int64
+	Offset     int64
+	Unpacked   bool
+	Executable bool
+	Link       string
+	Integrity  *Integrity
+	Files      map[string]*Entry
+}
+
+type jsonEntry struct {
+	Size       *int64            `json:"size,omitempty"`
+	Offset     string            `json:"offset,omitempty"`
+	Unpacked   bool              `json:"unpacked,omitempty"`
+	Executable bool              `json:"executable,omitempty"`
+	Link       string            `json:"link,omitempty"`
+	Integrity  *Integrity        `json:"integrity,omitempty"`
+	Files      map[string]*Entry `json:"files,omitempty"`
+}
+
+func (entry *Entry) IsDir() bool {
+	return entry.Files != nil
+}
+
+func (entry *Entry) MarshalJSON() ([]byte, error) {
+	if entry.IsDir() {
+		return json.Marshal(struct {
+			Files map[string]*Entry `json:"files"`
+		}{entry.Files})
+	}
+	if entry.Link != "" {
+		return json.Marshal(jsonEntry{Link: entry.Link})
+	}
+
+	out := jsonEntry{
+		Size:       &entry.Size,
+		Unpacked:   entry.Unpacked,
+		Executable: entry.Executable,
+		Integrity:  entry.Integrity,
+	}
+	if !entry.Unpacked {
+		out.Offset = strconv.FormatInt(entry.Offset, 10)
+	}
+	return json.Marshal(out)
+}
+
+func (entry *Entry) UnmarshalJSON(data []byte) error {
+	var in jsonEntry
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	*entry = Entry{
+		Unpacked:   in.Unpacked,
+		Executable: in.Executable,
+		Link:       in.Link,
+		Integrity:  in.Integrity,
+		Files:      in.Files,
+	}
+	if in.Size != nil {
+		if *in.Size < 0 {
+			return fmt.Errorf("asar: invalid size %d", *in.Size)
+		}
+		entry.Size = *in.Size
+	}
+	if in.Offset != "" {
+		offset, err := strconv.ParseInt(in.Offset, 10, 64)
+		if err != nil || offset < 0 {
+			return fmt.Errorf("asar: invalid offset %q", in.Offset)
+		}
+		entry.Offset = offset
+	}
+	return nil
+}
+
+type Archive struct {
+	Root        *Entry
+	UnpackedDir string
+	data        []byte
+	modified    map[string][]byte
+}
+
+func New() *Archive {
+	return &Archive{
+		Root:     &Entry{Files: map[string]*Entry{}},
+		modified: map[string][]byte{},
+	}
+}
+
+func Decode(data []byte) (*Archive, error) {
+	if len(data) < 16 {
+		return nil, errors.New("asar: archive is too short")
+	}
+	headerSize := int(binary.LittleEndian.Uint32(data[4:8]))
+	jsonSize := int(binary.LittleEndian.Uint32(data[12:16]))
+	if 8+headerSize > len(data) || 16+jsonSize > 8+headerSize {
+		return nil, errors.New("asar: invalid header size")
+	}
+
+	root := &Entry{}
+	if err := json.Unmarshal(data[16:16+jsonSize], root); err != nil {
+		return nil, fmt.Errorf("asar: invalid header: %w", err)
+	}
+	if !root.IsDir() {
+		return nil, errors.New("asar: header has no files")
+	}
+	return &Archive{
+		Root:     root,
+		data:     data[8+headerSize:],
+		modified: map[string][]byte{},
+	}, nil
+}
+
+func Open(archivePath string) (*Archive, error) {
+	data, err := os.ReadFile(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	archive.UnpackedDir = archivePath + ".unpacked"
+	return archive, nil
+}
+
+func splitPath(name string) []string {
+	return strings.FieldsFunc(path.Clean("/"+filepath.ToSlash(name)), func(r rune) bool { return r == '/' })
+}
+
+func (archive *Archive) Find(name string) (*Entry, error) {
+	entry := archive.Root
+	for _, part := range splitPath(name) {
+		if !entry.IsDir() {
+			return nil, ErrNotFound
+		}
+		child, ok := entry.Files[part]
+		if !ok {
+			return nil, ErrNotFound
+		}
+		entry = child
+	}
+	return entry, nil
+}
+
+func (archive *Archive) resolve(name string) (string, *Entry, error) {
+	for i := 0; i < 32; i++ {
+		entry, err := archive.Find(name)
+		if err != nil {
+			return "", nil, err
+		}
+		if entry.Link == "" {
+			return path.Join(splitPath(name)...), entry, nil
+		}
+		name = entry.Link
+	}
+	return "", nil, fmt.Errorf("asar: too many links: %s", name)
+}
+
+func (archive *Archive) ReadFile(name string) ([]byte, error) {
+	name, entry, err := archive.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	if entry.IsDir() {
+		return nil, fmt.Errorf("asar: %s is a directory", name)
+	}
+	if data, ok := archive.modified[name]; ok {
+		return data, nil
+	}
+	if entry.Unpacked {
+		if archive.UnpackedDir == "" {
+			return nil, fmt.Errorf("asar: %s is unpacked but the archive has no unpacked dir", name)
+		}
+		return os.ReadFile(filepath.Join(archive.UnpackedDir, filepath.FromSlash(name)))
+	}
+	if entry.Offset < 0 || entry.Size < 0 || entry.Offset > int64(len(archive.data))-entry.Size {
+		return nil, fmt.Errorf("asar: %s is out of bounds", name)
+	}
+	return archive.data[entry.Offset : entry.Offset+entry.Size], nil
+}
+
+func (archive *Archive) WriteFile(name string, data []byte) error {
+	parts := splitPath(name)
+	if len(parts) == 0 {
+		return fmt.Errorf("asar: invalid name %q", name)
+	}
+
+	dir := archive.Root
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := dir.Files[part]
+		if !ok {
+			child = &Entry{Files: map[string]*Entry{}}
+			dir.Files[part] = child
+		}
+		if !child.IsDir() {
+			return fmt.Errorf("asar: %s is not a directory", part)
+		}
+		dir = child
+	}
+
+	entry, ok := dir.Files[parts[len(parts)-1]]
+	if !ok {
+		entry = &Entry{}
+		dir.Files[parts[len(parts)-1]] = entry
+	}
+	if entry.IsDir() || entry.Link != "" {
+		return fmt.Errorf("asar: %s is not a regular file", name)
+	}
+
+	entry.Size = int64(len(data))
+	entry.Integrity = integrity(data)
+	archive.modified[path.Join(parts...)] = data
+	return nil
+}
+
+func (archive *Archive) Walk(fn func(name string, entry *Entry) error) error {
+	return walk(archive.Root, "", fn)
+}
+
+func walk(dir *Entry, prefix string, fn func(name string, entry *Entry) error) error {
+	names := make([]string, 0, len(dir.Files))
+	for name := range dir.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		entry := dir.Files[name]
+		entryPath := path.Join(prefix, name)
+		if err := fn(entryPath, entry); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if err := walk(entry, entryPath, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func integrity(data []byte) *Integrity {
+	sum := sha256.Sum256(data)
+	result := &Integrity{
+		Algorithm: "SHA256",
+		Hash:      hex.EncodeToString(sum[:]),
+		BlockSize: BLOCK_SIZE,
+		Blocks:    []string{},
+	}
+	for start := 0; start < len(data) || start == 0; start += BLOCK_SIZE {
+		end := start + BLOCK_SIZE
+		if end > len(data) {
+			end = len(data)
+		}
+		block := sha256.Sum256(data[start:end])
+		result.Blocks = append(result.Blocks, hex.EncodeToString(block[:]))
+		if end == len(data) {
+			break
+		}
+	}
+	return result
+}
+
+func (archive *Archive) Encode(w io.Writer) error {
+	var content bytes.Buffer
+	err := archive.Walk(func(name string, entry *Entry) error {
+		if entry.IsDir() || entry.Link != "" || entry.Unpacked {
+			return nil
+		}
+		data, err := archive.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		entry.Offset = int64(content.Len())
+		entry.Size = int64(len(data))
+		content.Write(data)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	header, err := json.Marshal(archive.Root)
+	if err != nil {
+		return err
+	}
+	padding := (4 - len(header)%4) % 4
+	prefix := make([]byte, 16)
+	binary.LittleEndian.PutUint32(prefix[0:4], 4)
+	binary.LittleEndian.PutUint32(prefix[4:8], uint32(8+len(header)+padding))
+	binary.LittleEndian.PutUint32(prefix[8:12], uint32(4+len(header)+padding))
+	binary.LittleEndian.PutUint32(prefix[12:16], uint32(len(header)))
+
+	for _, chunk := range [][]byte{prefix, header, make([]byte, padding), content.Bytes()} {
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+
+	archive.data = content.Bytes()
+	for name := range archive.modified {
+		if entry, err := archive.Find(name); err == nil && !entry.Unpacked {
+			delete(archive.modified, name)
+		}
+	}
+	return nil
+}
+
+func (archive *Archive) Save(archivePath string) error {
+	for name, data := range archive.modified {
+		entry, err := archive.Find(name)
+		if err != nil || !entry.Unpacked {
+			continue
+		}
+		unpackedPath := filepath.Join(archivePath+".unpacked", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(unpackedPath), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(unpackedPath, data, 0644); err != nil {
+			return err
+		}
+	}
+
+	var out bytes.Buffer
+	if err := archive.Encode(&out); err != nil {
+		return err
+	}
+	return os.WriteFile(archivePath, out.Bytes(), 0644)
+}
@@ -0,0 +1,227 @@
+package asar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func encodeArchive(t *testing.T, archive *Archive) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	if err := archive.Encode(&out); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// Builds an archive around a raw header, the way asar lays it out
+func rawArchive(header string, content string) []byte {
+	prefix := make([]byte, 16)
+	binary.LittleEndian.PutUint32(prefix[0:4], 4)
+	binary.LittleEndian.PutUint32(prefix[4:8], uint32(8+len(header)))
+	binary.LittleEndian.PutUint32(prefix[8:12], uint32(4+len(header)))
+	binary.LittleEndian.PutUint32(prefix[12:16], uint32(len(header)))
+	return append(append(prefix, header...), content...)
+}
+
+func TestPackedRoundTrip(t *testing.T) {
+	files := map[string]string{
+		"main.js":              "console.log('main')",
+		"package.json":         `{"name": "obsidian"}`,
+		"lib/nested/deep.js":   "module.exports = 1",
+		"lib/empty.txt":        "",
+		"node_modules/a/b.css": "body {}",
+	}
+	archive := New()
+	for name, content := range files {
+		if err := archive.WriteFile(name, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	decoded, err := Decode(encodeArchive(t, archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		data, err := decoded.ReadFile(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if string(data) != content {
+			t.Errorf("%s: got %q, want %q", name, data, content)
+		}
+	}
+	if _, err := decoded.ReadFile("missing.js"); err != ErrNotFound {
+		t.Errorf("missing file: got %v, want ErrNotFound", err)
+	}
+	if _, err := decoded.ReadFile("lib"); err == nil {
+		t.Error("reading a directory should fail")
+	}
+
+	if err := decoded.WriteFile("main.js", []byte("patched")); err != nil {
+		t.Fatal(err)
+	}
+	reencoded, err := Decode(encodeArchive(t, decoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if name == "main.js" {
+			content = "patched"
+		}
+		if data, err := reencoded.ReadFile(name); err != nil || string(data) != content {
+			t.Errorf("%s after rewrite: got %q, %v, want %q", name, data, err, content)
+		}
+	}
+}
+
+func TestLinks(t *testing.T) {
+	archive, err := Decode(rawArchive(`{"files":{"target.js":{"size":5,"offset":"0"},"link.js":{"link":"target.js"},"loop.js":{"link":"loop.js"}}}`, "hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := archive.ReadFile("link.js"); err != nil || string(data) != "hello" {
+		t.Errorf("link: got %q, %v", data, err)
+	}
+	if _, err := archive.ReadFile("loop.js"); err == nil {
+		t.Error("a link loop should fail")
+	}
+}
+
+func TestUnpackedRoundTrip(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "app.asar")
+	archive := New()
+	for name, content := range map[string]string{"main.js": "packed", "native/addon.node": "native addon"} {
+		if err := archive.WriteFile(name, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entry, err := archive.Find("native/addon.node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Unpacked = true
+	if err := archive.Save(archivePath); err != nil {
+		t.Fatal(err)
+	}
+
+	unpacked, err := os.ReadFile(filepath.Join(archivePath+".unpacked", "native", "addon.node"))
+	if err != nil || string(unpacked) != "native addon" {
+		t.Fatalf("unpacked file: got %q, %v", unpacked, err)
+	}
+	data, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("native addon")) {
+		t.Error("unpacked file content is in the archive")
+	}
+
+	opened, err := Open(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"main.js": "packed", "native/addon.node": "native addon"} {
+		if data, err := opened.ReadFile(name); err != nil || string(data) != content {
+			t.Errorf("%s: got %q, %v, want %q", name, data, err, content)
+		}
+	}
+	if entry, _ := opened.Find("native/addon.node"); !entry.Unpacked {
+		t.Error("unpacked flag was not kept")
+	}
+
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decoded.ReadFile("native/addon.node"); err == nil {
+		t.Error("reading an unpacked file without the unpacked dir should fail")
+	}
+}
+
+func TestIntegrity(t *testing.T) {
+	for _, size := range []int{0, 10, BLOCK_SIZE, BLOCK_SIZE + 1, 2*BLOCK_SIZE + 3} {
+		data := bytes.Repeat([]byte("a"), size)
+		archive := New()
+		if err := archive.WriteFile("file.bin", data); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := Decode(encodeArchive(t, archive))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, err := decoded.Find("file.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sum := sha256.Sum256(data)
+		if entry.Integrity == nil || entry.Integrity.Algorithm != "SHA256" || entry.Integrity.Hash != hex.EncodeToString(sum[:]) {
+			t.Fatalf("size %d: wrong integrity %+v", size, entry.Integrity)
+		}
+		blocks := (size + BLOCK_SIZE - 1) / BLOCK_SIZE
+		if blocks == 0 {
+			blocks = 1
+		}
+		if len(entry.Integrity.Blocks) != blocks {
+			t.Errorf("size %d: got %d blocks, want %d", size, len(entry.Integrity.Blocks), blocks)
+		}
+		for i, block := range entry.Integrity.Blocks {
+			end := (i + 1) * BLOCK_SIZE
+			if end > size {
+				end = size
+			}
+			blockSum := sha256.Sum256(data[i*BLOCK_SIZE : end])
+			if block != hex.EncodeToString(blockSum[:]) {
+				t.Errorf("size %d: block %d has the wrong hash", size, i)
+			}
+		}
+	}
+}
+
+func TestMalformedArchives(t *testing.T) {
+	oversized := rawArchive(`{"files":{}}`, "")
+	binary.LittleEndian.PutUint32(oversized[4:8], uint32(len(oversized)))
+
+	for _, test := range []struct {
+		name    string
+		data    []byte
+		decode  string
+		read    string
+		readErr string
+	}{
+		{name: "too short", data: []byte("asar"), decode: "too short"},
+		{name: "header past the end", data: oversized, decode: "invalid header size"},
+		{name: "invalid json", data: rawArchive(`{"files":`, ""), decode: "invalid header"},
+		{name: "no files", data: rawArchive(`{"size":1}`, ""), decode: "no files"},
+		{name: "non numeric offset", data: rawArchive(`{"files":{"a.js":{"size":1,"offset":"x"}}}`, "a"), decode: "invalid offset"},
+		{name: "negative offset", data: rawArchive(`{"files":{"a.js":{"size":1,"offset":"-5"}}}`, "a"), decode: "invalid offset"},
+		{name: "negative size", data: rawArchive(`{"files":{"a.js":{"size":-1,"offset":"0"}}}`, "a"), decode: "invalid size"},
+		{name: "offset out of bounds", data: rawArchive(`{"files":{"a.js":{"size":1,"offset":"5"}}}`, "a"), read: "a.js", readErr: "out of bounds"},
+		{name: "size out of bounds", data: rawArchive(`{"files":{"a.js":{"size":2,"offset":"0"}}}`, "a"), read: "a.js", readErr: "out of bounds"},
+		{name: "overflowing size", data: rawArchive(`{"files":{"a.js":{"size":9223372036854775807,"offset":"1"}}}`, "a"), read: "a.js", readErr: "out of bounds"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			archive, err := Decode(test.data)
+			if test.decode != "" {
+				if err == nil || !strings.Contains(err.Error(), test.decode) {
+					t.Fatalf("got %v, want an error containing %q", err, test.decode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := archive.ReadFile(test.read); err == nil || !strings.Contains(err.Error(), test.readErr) {
+				t.Errorf("got %v, want an error containing %q", err, test.readErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// Font services pick the font format from the user agent
+const ASSET_USER_AGENT = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) obsidian/1.6.7 Chrome/124.0.6367.243 Electron/30.1.2 Safari/537.36"
+
+// Mirrored assets are stored by host and path, a query string is hashed
+// into the file name and a missing extension is taken from the content type
+func mirroredAssetPath(folder string, assetUrl *url.URL, contentType string) string {
+	assetPath := strings.TrimPrefix(path.Clean("/"+assetUrl.Path), "/")
+	if assetPath == "" {
+		assetPath = "index"
+	}
+	if assetUrl.RawQuery != "" {
+		sum := sha256.Sum256([]byte(assetUrl.RawQuery))
+		extension := path.Ext(assetPath)
+		assetPath = strings.TrimSuffix(assetPath, extension) + "-" + hex.EncodeToString(sum[:4]) + extension
+	}
+	if path.Ext(assetPath) == "" && contentType != "" {
+		if extensions, _ := mime.ExtensionsByType(contentType); len(extensions) > 0 {
+			assetPath += lo.Ternary(strings.HasPrefix(contentType, "text/css"), ".css", extensions[0])
+		}
+	}
+	return filepath.Join(folder, strings.ToLower(assetUrl.Hostname()), filepath.FromSlash(assetPath))
+}
+
+func fetchAsset(assetUrl *url.URL) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(http.MethodGet, assetUrl.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", ASSET_USER_AGENT)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, nil, fmt.Errorf("[!] Error downloading asset: %s, %s", assetUrl, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	return resp, body, err
+}
+
+func writeAsset(assetPath string, assetUrl *url.URL, resp *http.Response, body []byte) error {
+	if err := os.MkdirAll(filepath.Dir(assetPath), 0755); err != nil {
+		return err
+	}
+	if err := replaceFile(assetPath, body, 0644); err != nil {
+		return err
+	}
+	provenance.recordDownload(assetUrl.String(), assetPath, resp, body)
+	return nil
+}
+
+// A rewritten file is kept while it's newer than its source and was
+// rewritten for the same server address and options
+func isRewritten(filePath string, sourceInfo os.FileInfo, rewrittenFor string) bool {
+	info, err := os.Stat(filePath)
+	return err == nil && !info.ModTime().Before(sourceInfo.ModTime()) && provenance.rewrittenFor(filePath) == rewrittenFor
+}
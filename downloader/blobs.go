@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type DedupStats struct {
+	Files      int   `json:"files"`
+	Blobs      int   `json:"blobs"`
+	Size       int64 `json:"size"`
+	StoredSize int64 `json:"storedSize"`
+	Saved      int64 `json:"saved"`
+}
+
+func blobPath(blobsFolder string, digest string) string {
+	return filepath.Join(blobsFolder, "sha256", digest[:2], digest)
+}
+
+// Writes through a temp file and a rename, so a file hardlinked to a blob is
+// replaced instead of changing the blob and every other file linked to it
+func writeReplacing(filePath string, perm os.FileMode, write func(out io.Writer) error) error {
+	out, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	if err := write(out); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(out.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), filePath)
+}
+
+func replaceFile(filePath string, data []byte, perm os.FileMode) error {
+	return writeReplacing(filePath, perm, func(out io.Writer) error {
+		_, err := out.Write(data)
+		return err
+	})
+}
+
+// The obsidian releases folder is a git checkout, git writes its files in place
+func isBlobStored(file string) bool {
+	return !strings.HasPrefix(file, OBSIDIAN_GITHUB_PATH+"/")
+}
+
+func linkBlob(blob string, filePath string) error {
+	linkPath := filePath + ".blob.tmp"
+	os.Remove(linkPath)
+	if err := os.Link(blob, linkPath); err != nil {
+		return err
+	}
+	return os.Rename(linkPath, filePath)
+}
+
+func pruneBlobs(blobsFolder string, referenced map[string]int64) error {
+	prefixes, _ := filepath.Glob(filepath.Join(blobsFolder, "sha256", "*"))
+	for _, prefix := range prefixes {
+		entries, err := os.ReadDir(prefix)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if _, ok := referenced[entry.Name()]; !ok {
+				if err := os.Remove(filepath.Join(prefix, entry.Name())); err != nil {
+					return err
+				}
+			}
+		}
+		os.Remove(prefix)
+	}
+	return nil
+}
+
+// Files are linked to the blob of the digest the mirror index was just written
+// with, the first file with a digest becomes its blob
+func storeBlobs(downloadFolder string, blobsFolder string) (DedupStats, error) {
+	var stats DedupStats
+	sums, files, err := readMirrorIndex(downloadFolder)
+	if err != nil {
+		return stats, err
+	}
+
+	referenced := map[string]int64{}
+	for _, file := range files {
+		filePath := filepath.Join(downloadFolder, filepath.FromSlash(file))
+		info, err := os.Stat(filePath)
+		if !isBlobStored(file) || sums[file] == "" || err != nil {
+			continue
+		}
+		digest := sums[file]
+		blob := blobPath(blobsFolder, digest)
+		blobInfo, err := os.Stat(blob)
+		switch {
+		case os.IsNotExist(err):
+			if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+				return stats, err
+			}
+			if err := os.Link(filePath, blob); err != nil {
+				return stats, fmt.Errorf("[!] Error storing blob, %s must be on the same file system as %s: %s", blobsFolder, downloadFolder, err)
+			}
+		case err != nil:
+			return stats, err
+		case blobInfo.Size() != info.Size():
+			log.Printf("[!] %s changed since the mirror index was written, not linking it to its blob\n\n", filePath)
+			continue
+		case !os.SameFile(info, blobInfo):
+			if err := linkBlob(blob, filePath); err != nil {
+				return stats, err
+			}
+		}
+		stats.Files++
+		stats.Size += info.Size()
+		referenced[digest] = info.Size()
+	}
+
+	for _, size := range referenced {
+		stats.StoredSize += size
+	}
+	stats.Blobs = len(referenced)
+	stats.Saved = stats.Size - stats.StoredSize
+	return stats, pruneBlobs(blobsFolder, referenced)
+}
+
+func storeMirrorBlobs(downloadFolder string) (DedupStats, error) {
+	if !config.Blobs.Enabled {
+		return DedupStats{}, nil
+	}
+	return storeBlobs(downloadFolder, config.Blobs.Folder)
+}
+
+// Files linked to the blob of their indexed digest share its content, so each
+// blob is only hashed once
+type blobDigests map[string]string
+
+func (digests blobDigests) sha256(filePath string, digest string) (string, error) {
+	if !config.Blobs.Enabled || digest == "" {
+		return fileSha256(filePath)
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	blobInfo, err := os.Stat(blobPath(config.Blobs.Folder, digest))
+	if err != nil || !os.SameFile(info, blobInfo) {
+		return fileSha256(filePath)
+	}
+	if sha, ok := digests[digest]; ok {
+		return sha, nil
+	}
+	sha, err := fileSha256(filePath)
+	if err == nil {
+		digests[digest] = sha
+	}
+	return sha, err
+}
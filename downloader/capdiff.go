@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+var (
+	ENDPOINT_REGEX        = regexp.MustCompile(`\b(?:https?|wss?)://[a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)+(?::\d+)?`)
+	REQUIRE_REGEX         = regexp.MustCompile("\\brequire\\(\\s*[\"'`]([^\"'`]+)[\"'`]\\s*\\)")
+	ELECTRON_API_REGEX    = regexp.MustCompile(`\b(ipcRenderer|BrowserWindow|webContents|webFrame|desktopCapturer|safeStorage|powerMonitor|shell\.(?:openExternal|openPath|showItemInFolder|trashItem)|remote\.[a-zA-Z]+)\b`)
+	DYNAMIC_CODE_PATTERNS = []struct {
+		Name  string
+		Regex *regexp.Regexp
+	}{
+		{"eval", regexp.MustCompile(`\beval\s*\(`)},
+		{"new Function", regexp.MustCompile(`\bnew\s+Function\s*\(`)},
+		{"computed import()", regexp.MustCompile("\\bimport\\s*\\(\\s*[^\"'`\\s)]")},
+		{"string timer", regexp.MustCompile("\\bset(?:Timeout|Interval)\\s*\\(\\s*[\"'`]")},
+		{"WebAssembly", regexp.MustCompile(`\bWebAssembly\.(?:instantiate|compile)`)},
+		{"node vm", regexp.MustCompile(`\bvm\.runIn\w+`)},
+	}
+)
+
+type Capabilities struct {
+	Size         int64
+	Endpoints    []string
+	Requires     []string
+	ElectronApis []string
+	DynamicCode  []string
+}
+
+type CapabilityChange struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+type CapabilityDiff struct {
+	From         string           `json:"from"`
+	To           string           `json:"to"`
+	SizeBefore   int64            `json:"sizeBefore"`
+	SizeAfter    int64            `json:"sizeAfter"`
+	Endpoints    CapabilityChange `json:"endpoints"`
+	Requires     CapabilityChange `json:"requires"`
+	ElectronApis CapabilityChange `json:"electronApis"`
+	DynamicCode  CapabilityChange `json:"dynamicCode"`
+}
+
+func findCapabilities(script string, regex *regexp.Regexp, normalize func(match []string) string) []string {
+	found := lo.Uniq(lo.Map(regex.FindAllStringSubmatch(script, -1), func(match []string, _ int) string { return normalize(match) }))
+	sort.Strings(found)
+	return found
+}
+
+func dynamicCode(script string) []string {
+	var found []string
+	for _, pattern := range DYNAMIC_CODE_PATTERNS {
+		if pattern.Regex.MatchString(script) {
+			found = append(found, pattern.Name)
+		}
+	}
+	return found
+}
+
+func scriptCapabilities(script string) Capabilities {
+	whole := func(match []string) string { return match[0] }
+	return Capabilities{
+		Size:         int64(len(script)),
+		Endpoints:    findCapabilities(script, ENDPOINT_REGEX, func(match []string) string { return strings.ToLower(match[0]) }),
+		Requires:     findCapabilities(script, REQUIRE_REGEX, func(match []string) string { return strings.TrimPrefix(match[1], "node:") }),
+		ElectronApis: findCapabilities(script, ELECTRON_API_REGEX, whole),
+		DynamicCode:  dynamicCode(script),
+	}
+}
+
+func compareCapabilities(before []string, after []string) CapabilityChange {
+	added, removed := lo.Difference(after, before)
+	return CapabilityChange{Added: added, Removed: removed}
+}
+
+func diffCapabilities(from string, before Capabilities, to string, after Capabilities) CapabilityDiff {
+	return CapabilityDiff{
+		From:         from,
+		To:           to,
+		SizeBefore:   before.Size,
+		SizeAfter:    after.Size,
+		Endpoints:    compareCapabilities(before.Endpoints, after.Endpoints),
+		Requires:     compareCapabilities(before.Requires, after.Requires),
+		ElectronApis: compareCapabilities(before.ElectronApis, after.ElectronApis),
+		DynamicCode:  compareCapabilities(before.DynamicCode, after.DynamicCode),
+	}
+}
+
+func diffScriptFiles(from string, fromPath string, to string, toPath string) (CapabilityDiff, error) {
+	before, err := os.ReadFile(fromPath)
+	if err != nil {
+		return CapabilityDiff{}, err
+	}
+	after, err := os.ReadFile(toPath)
+	if err != nil {
+		return CapabilityDiff{}, err
+	}
+	return diffCapabilities(from, scriptCapabilities(string(before)), to, scriptCapabilities(string(after))), nil
+}
+
+func mirroredPluginScript(downloadFolder string, repo string, version string) string {
+	releaseFolder := pluginReleaseFolder(filepath.Join(downloadFolder, repo), version)
+	if _, err := os.Stat(releaseFolder); os.IsNotExist(err) {
+		return pluginScriptPath(quarantineFolder(repo, version))
+	}
+	return pluginScriptPath(releaseFolder)
+}
+
+func writeCapabilityDiff(out io.Writer, repo string, diff CapabilityDiff) {
+	fmt.Fprintf(out, "# %s %s -> %s\n\n", repo, diff.From, diff.To)
+	fmt.Fprintf(out, "main.js: %.1f KiB -> %.1f KiB (%+d bytes)\n", float64(diff.SizeBefore)/1024, float64(diff.SizeAfter)/1024, diff.SizeAfter-diff.SizeBefore)
+	for _, section := range []struct {
+		Name   string
+		Change CapabilityChange
+	}{
+		{"Network endpoints", diff.Endpoints},
+		{"Node modules", diff.Requires},
+		{"Electron apis", diff.ElectronApis},
+		{"Dynamic code", diff.DynamicCode},
+	} {
+		if len(section.Change.Added) == 0 && len(section.Change.Removed) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "\n## %s\n\n", section.Name)
+		for _, added := range section.Change.Added {
+			fmt.Fprintf(out, "+ %s\n", added)
+		}
+		for _, removed := range section.Change.Removed {
+			fmt.Fprintf(out, "- %s\n", removed)
+		}
+	}
+}
+
+func capdiffCommand(args []string) error {
+	flags := flag.NewFlagSet("capdiff", flag.ExitOnError)
+	format := flags.String("format", "markdown", "Report format, markdown or json")
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 3 {
+		return fmt.Errorf("[!] Usage: capdiff <owner/repo> <from version> <to version>")
+	}
+
+	repo, from, to := flags.Arg(0), flags.Arg(1), flags.Arg(2)
+	diff, err := diffScriptFiles(from, mirroredPluginScript(DOWNLOAD_FOLDER, repo, from), to, mirroredPluginScript(DOWNLOAD_FOLDER, repo, to))
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(diff)
+	}
+	writeCapabilityDiff(os.Stdout, repo, diff)
+	return nil
+}
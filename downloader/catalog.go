@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"flag"
+	"html/template"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	PLUGIN_STATS_FILENAME = "community-plugin-stats.json"
+	CATALOG_INDEX_FILE    = "index.html"
+	// Screenshots are shown 240px wide, thumbnails keep them sharp on hidpi screens
+	CATALOG_SCREENSHOT_WIDTH = 480
+)
+
+var (
+	//go:embed catalog.html
+	CATALOG_TEMPLATE     string
+	CATALOG_FOLDER       = filepath.Join(".", "catalog")
+	CATALOG_SCRIPT_REGEX = regexp.MustCompile(`(?s)<script>(.*?)</script>`)
+)
+
+// The page's content security policy only allows its own inline script, so
+// markup that gets through the markdown sanitizer still can't run anything
+func catalogScriptHash() string {
+	sum := sha256.Sum256([]byte(CATALOG_SCRIPT_REGEX.FindStringSubmatch(CATALOG_TEMPLATE)[1]))
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+type CatalogEntry struct {
+	Name         string
+	Author       string
+	Description  string
+	Version      string
+	ReleaseNotes template.HTML
+	Downloads    int
+	Offline      string
+	Modes        string
+	Screenshot   string
+	Readme       string
+	Files        string
+	InstallUrl   template.URL
+	Search       string
+}
+
+type Catalog struct {
+	GeneratedAt time.Time
+	ScriptHash  string
+	Plugins     []CatalogEntry
+	Themes      []CatalogEntry
+}
+
+// Links from the catalog folder to a mirrored file, the catalog is served next to files/
+func catalogFileUrl(repo string, file string) string {
+	segments := strings.Split(repo+"/"+file, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return "../files/" + strings.Join(segments, "/")
+}
+
+func catalogReadmeUrl(repoFolder string, repo string) string {
+	for _, file := range []string{README_HTML_FILE, README_FILE} {
+		if _, err := os.Stat(filepath.Join(repoFolder, file)); err == nil {
+			return catalogFileUrl(repo, file)
+		}
+	}
+	return ""
+}
+
+func catalogSearchText(values ...string) string {
+	return strings.ToLower(strings.Join(values, " "))
+}
+
+func readPluginDownloads(downloadFolder string) map[string]int {
+	var stats map[string]struct {
+		Downloads int `json:"downloads"`
+	}
+	downloads := map[string]int{}
+	if err := readJsonFile(filepath.Join(downloadFolder, OBSIDIAN_GITHUB_PATH, PLUGIN_STATS_FILENAME), &stats); err != nil {
+		return downloads
+	}
+	for id, stat := range stats {
+		downloads[id] = stat.Downloads
+	}
+	return downloads
+}
+
+func readThemeDownloads(downloadFolder string) map[string]int {
+	var stats map[string]struct {
+		Download int `json:"download"`
+	}
+	downloads := map[string]int{}
+	if err := readJsonFile(filepath.Join(downloadFolder, "stats", "theme"), &stats); err != nil {
+		return downloads
+	}
+	for name, stat := range stats {
+		downloads[name] = stat.Download
+	}
+	return downloads
+}
+
+func sortCatalogEntries(entries []CatalogEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Downloads != entries[j].Downloads {
+			return entries[i].Downloads > entries[j].Downloads
+		}
+		return strings.ToLower(entries[i].Name) < strings.ToLower(entries[j].Name)
+	})
+}
+
+// Only what is mirrored is listed, plugins and themes without a manifest yet are left out
+func buildCatalog(downloadFolder string) (Catalog, error) {
+	catalog := Catalog{GeneratedAt: time.Now().UTC(), ScriptHash: catalogScriptHash()}
+	plugins, err := readCommunityPlugins(downloadFolder)
+	if err != nil {
+		return catalog, err
+	}
+	themes, err := readCommunityThemes(downloadFolder)
+	if err != nil {
+		return catalog, err
+	}
+
+	pluginDownloads := readPluginDownloads(downloadFolder)
+	for _, plugin := range plugins {
+		repoFolder := filepath.Join(downloadFolder, plugin.Repo)
+		manifest, err := readManifest(repoFolder)
+		if err != nil {
+			continue
+		}
+		entry := CatalogEntry{
+			Name:        plugin.Name,
+			Author:      plugin.Author,
+			Description: plugin.Description,
+			Version:     manifest.Version,
+			Downloads:   pluginDownloads[plugin.Id],
+			Readme:      catalogReadmeUrl(repoFolder, plugin.Repo),
+			Files:       catalogFileUrl(plugin.Repo, ""),
+			InstallUrl:  template.URL("obsidian://show-plugin?id=" + url.QueryEscape(plugin.Id)),
+			Search:      catalogSearchText(plugin.Id, plugin.Name, plugin.Author, plugin.Description),
+		}
+		if classification, err := classifyPluginOffline(repoFolder, plugin.Repo); err == nil {
+			entry.Offline = classification.Class
+		}
+		// Rendered markdown only keeps allowed tags and closes the ones left open, see renderMarkdown
+		if notes := readReleaseNotes(pluginReleaseFolder(repoFolder, manifest.Version)); notes != "" {
+			entry.ReleaseNotes = template.HTML(renderMarkdown(notes))
+		}
+		catalog.Plugins = append(catalog.Plugins, entry)
+	}
+
+	themeDownloads := readThemeDownloads(downloadFolder)
+	for _, theme := range themes {
+		repoFolder := filepath.Join(downloadFolder, theme.Repo)
+		manifest, err := readManifest(repoFolder)
+		if err != nil {
+			continue
+		}
+		entry := CatalogEntry{
+			Name:       theme.Name,
+			Author:     theme.Author,
+			Version:    manifest.Version,
+			Downloads:  themeDownloads[theme.Name],
+			Modes:      strings.Join(theme.Modes, ", "),
+			Readme:     catalogReadmeUrl(repoFolder, theme.Repo),
+			Files:      catalogFileUrl(theme.Repo, ""),
+			InstallUrl: template.URL("obsidian://show-theme?name=" + url.QueryEscape(theme.Name)),
+			Search:     catalogSearchText(theme.Name, theme.Author),
+		}
+		if _, err := os.Stat(filepath.Join(repoFolder, theme.Screenshot)); theme.Screenshot != "" && err == nil {
+			entry.Screenshot = catalogFileUrl(theme.Repo, theme.Screenshot)
+		}
+		if thumbnail, ok := screenshotThumbnail(repoFolder, theme.Screenshot, CATALOG_SCREENSHOT_WIDTH); ok {
+			entry.Screenshot = catalogFileUrl(theme.Repo, thumbnail.Path)
+		}
+		catalog.Themes = append(catalog.Themes, entry)
+	}
+
+	sortCatalogEntries(catalog.Plugins)
+	sortCatalogEntries(catalog.Themes)
+	return catalog, nil
+}
+
+func writeCatalog(downloadFolder string, catalogFolder string) error {
+	catalog, err := buildCatalog(downloadFolder)
+	if err != nil {
+		return err
+	}
+	page, err := template.New(CATALOG_INDEX_FILE).Parse(CATALOG_TEMPLATE)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(catalogFolder, 0755); err != nil {
+		return err
+	}
+
+	indexPath := filepath.Join(catalogFolder, CATALOG_INDEX_FILE)
+	file, err := os.Create(indexPath + ".tmp")
+	if err != nil {
+		return err
+	}
+	if err := page.Execute(file, catalog); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(indexPath+".tmp", indexPath)
+}
+
+func catalogCommand(args []string) error {
+	flags := flag.NewFlagSet("catalog", flag.ExitOnError)
+	output := flags.String("o", CATALOG_FOLDER, "Catalog folder")
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+
+	if err := writeCatalog(DOWNLOAD_FOLDER, *output); err != nil {
+		return err
+	}
+	log.Printf("[*] Wrote catalog to %s", filepath.Join(*output, CATALOG_INDEX_FILE))
+	return nil
+}
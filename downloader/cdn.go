@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+const PLUGIN_SCRIPT_FILE = "main.js"
+
+var CDN_FOLDER = filepath.Join(DOWNLOAD_FOLDER, "cdn")
+
+func rewritesPluginScripts() bool {
+	return config.Cdn.Enabled && config.Cdn.RewriteScripts && config.Patch.ServerAddress != ""
+}
+
+// The upstream script, kept as main.js.orig when the served one is rewritten
+func pluginScriptPath(releaseFolder string) string {
+	scriptPath := filepath.Join(releaseFolder, PLUGIN_SCRIPT_FILE)
+	if _, err := os.Stat(scriptPath + ORIGINAL_FILE_SUFFIX); err == nil {
+		return scriptPath + ORIGINAL_FILE_SUFFIX
+	}
+	return scriptPath
+}
+
+func cdnUrlRegex() *regexp.Regexp {
+	hosts := lo.Map(config.Cdn.Hosts, func(host string, _ int) string { return regexp.QuoteMeta(host) })
+	return regexp.MustCompile(`https?://(?:` + strings.Join(hosts, "|") + `)/[^\s"'` + "`" + `)\\${}<>]+`)
+}
+
+// Urls built at runtime, by concatenation or template literals, can't be mirrored
+func isCompleteCdnUrl(script string, end int) bool {
+	if strings.HasSuffix(script[:end], "/") {
+		return false
+	}
+	rest := strings.TrimLeft(script[end:], "\"'`")
+	return !strings.HasPrefix(rest, "$") && !strings.HasPrefix(strings.TrimSpace(rest), "+")
+}
+
+func findCdnUrls(script string) []string {
+	if len(config.Cdn.Hosts) == 0 {
+		return nil
+	}
+	var cdnUrls []string
+	for _, match := range cdnUrlRegex().FindAllStringIndex(script, -1) {
+		if isCompleteCdnUrl(script, match[1]) {
+			cdnUrls = append(cdnUrls, script[match[0]:match[1]])
+		}
+	}
+	return lo.Uniq(cdnUrls)
+}
+
+func mirrorCdnAsset(cdnUrl string) (string, error) {
+	assetUrl, err := url.Parse(cdnUrl)
+	if err != nil {
+		return "", err
+	}
+	if assetPath := mirroredAssetPath(CDN_FOLDER, assetUrl, ""); filepath.Ext(assetPath) != "" {
+		if _, err := os.Stat(assetPath); err == nil {
+			return assetPath, nil
+		}
+	}
+
+	resp, body, err := fetchAsset(assetUrl)
+	if err != nil {
+		return "", err
+	}
+	assetPath := mirroredAssetPath(CDN_FOLDER, assetUrl, resp.Header.Get("Content-Type"))
+	return assetPath, writeAsset(assetPath, assetUrl, resp, body)
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := lo.Keys(values)
+	sort.Strings(keys)
+	return keys
+}
+
+// The served main.js goes back to the upstream one once scripts aren't rewritten
+func restorePluginScript(repo Repo, releaseFolder string, version string) error {
+	scriptPath := filepath.Join(releaseFolder, PLUGIN_SCRIPT_FILE)
+	if _, err := os.Stat(scriptPath + ORIGINAL_FILE_SUFFIX); err != nil {
+		return nil
+	}
+	if err := os.Rename(scriptPath+ORIGINAL_FILE_SUFFIX, scriptPath); err != nil {
+		return err
+	}
+	provenance.recordFile(scriptPath, pluginReleaseUrl(repo.Repo, version, PLUGIN_SCRIPT_FILE))
+	return nil
+}
+
+// Scripts are rewritten again when the server address or the cdn hosts change
+func cdnRewrittenFor() string {
+	return config.Patch.ServerAddress + " " + strings.Join(config.Cdn.Hosts, ",")
+}
+
+func mirrorPluginCdn(repo Repo, repoFolder string) ([]string, error) {
+	manifest, err := readManifest(repoFolder)
+	if os.IsNotExist(err) || err != nil && !config.Cdn.Enabled {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	releaseFolder := pluginReleaseFolder(repoFolder, manifest.Version)
+	if !rewritesPluginScripts() {
+		if err := restorePluginScript(repo, releaseFolder, manifest.Version); err != nil {
+			return nil, err
+		}
+	}
+	if !config.Cdn.Enabled {
+		return nil, nil
+	}
+	script, err := os.ReadFile(pluginScriptPath(releaseFolder))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mirrored := map[string]string{}
+	for _, cdnUrl := range findCdnUrls(string(script)) {
+		assetPath, err := mirrorCdnAsset(cdnUrl)
+		if err != nil {
+			log.Printf("%v\n\n", err)
+			continue
+		}
+		relativePath, _ := filepath.Rel(DOWNLOAD_FOLDER, assetPath)
+		mirrored[cdnUrl] = fmt.Sprintf("%s/%s", strings.TrimSuffix(config.Patch.ServerAddress, "/"), filepath.ToSlash(relativePath))
+	}
+
+	scriptPath := filepath.Join(releaseFolder, PLUGIN_SCRIPT_FILE)
+	origInfo, err := os.Stat(scriptPath + ORIGINAL_FILE_SUFFIX)
+	if !rewritesPluginScripts() || err != nil || isRewritten(scriptPath, origInfo, cdnRewrittenFor()) {
+		return sortedKeys(mirrored), nil
+	}
+
+	rewritten := cdnUrlRegex().ReplaceAllStringFunc(string(script), func(cdnUrl string) string {
+		if mirrorUrl, ok := mirrored[cdnUrl]; ok {
+			return mirrorUrl
+		}
+		return cdnUrl
+	})
+	if err := replaceFile(scriptPath, []byte(rewritten), 0644); err != nil {
+		return nil, err
+	}
+	provenance.recordRewritten(scriptPath, scriptPath+ORIGINAL_FILE_SUFFIX, cdnRewrittenFor())
+	return sortedKeys(mirrored), nil
+}
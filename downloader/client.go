@@ -0,0 +1,266 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+const (
+	BACKUP_FILE_SUFFIX      = ".bak"
+	DEFAULT_SERVER_ADDRESS  = "http://obsidian-server/files"
+	FLATPAK_APP_ID          = "md.obsidian.Obsidian"
+	OBSIDIAN_PROCESS_PREFIX = "obsidian"
+)
+
+var CLIENT_ASAR_FILES = []string{"obsidian.asar", "app.asar"}
+
+type ClientInstall struct {
+	Kind      string
+	Resources string
+	CacheDir  string
+	ReadOnly  bool
+}
+
+type clientLayout struct {
+	kind      string
+	resources []string
+	cacheDir  string
+	readOnly  bool
+}
+
+func clientLayouts(goos string, home string) []clientLayout {
+	if goos == "windows" {
+		localAppData, appData := os.Getenv("LOCALAPPDATA"), os.Getenv("APPDATA")
+		return []clientLayout{{
+			kind: "windows",
+			resources: []string{
+				filepath.Join(localAppData, "Obsidian", "resources"),
+				filepath.Join(localAppData, "Programs", "Obsidian", "resources"),
+			},
+			cacheDir: filepath.Join(appData, "obsidian"),
+		}}
+	}
+
+	configDir := filepath.Join(home, ".config", "obsidian")
+	return []clientLayout{
+		{kind: "deb", resources: []string{filepath.Join("/", "opt", "Obsidian", "resources")}, cacheDir: configDir},
+		{kind: "system", resources: []string{filepath.Join("/", "usr", "lib", "obsidian")}, cacheDir: configDir},
+		{
+			kind: "appimage",
+			resources: []string{
+				filepath.Join(home, "Applications", "squashfs-root", "resources"),
+				filepath.Join(home, "squashfs-root", "resources"),
+				filepath.Join(home, ".local", "share", "obsidian", "squashfs-root", "resources"),
+			},
+			cacheDir: configDir,
+		},
+		{
+			kind: "flatpak",
+			resources: []string{
+				filepath.Join("/", "var", "lib", "flatpak", "app", FLATPAK_APP_ID, "current", "active", "files", "main", "resources"),
+				filepath.Join(home, ".local", "share", "flatpak", "app", FLATPAK_APP_ID, "current", "active", "files", "main", "resources"),
+			},
+			cacheDir: filepath.Join(home, ".var", "app", FLATPAK_APP_ID, "config", "obsidian"),
+			readOnly: true,
+		},
+		{
+			kind:      "snap",
+			resources: []string{filepath.Join("/", "snap", "obsidian", "current", "resources")},
+			cacheDir:  filepath.Join(home, "snap", "obsidian", "current", ".config", "obsidian"),
+			readOnly:  true,
+		},
+	}
+}
+
+func underRoot(root string, path string) string {
+	if root == "" || root == "/" {
+		return path
+	}
+	return filepath.Join(root, strings.TrimPrefix(path, filepath.VolumeName(path)))
+}
+
+func containsClientAsar(folder string) bool {
+	return lo.SomeBy(CLIENT_ASAR_FILES, func(asarFile string) bool {
+		_, err := os.Stat(filepath.Join(folder, asarFile))
+		return err == nil
+	})
+}
+
+func detectClientInstalls(goos string, root string, home string) []ClientInstall {
+	var installs []ClientInstall
+	for _, layout := range clientLayouts(goos, home) {
+		for _, resources := range layout.resources {
+			resources = underRoot(root, resources)
+			if !containsClientAsar(resources) {
+				continue
+			}
+			installs = append(installs, ClientInstall{
+				Kind:      layout.kind,
+				Resources: resources,
+				CacheDir:  underRoot(root, layout.cacheDir),
+				ReadOnly:  layout.readOnly,
+			})
+		}
+	}
+	return installs
+}
+
+func (install ClientInstall) asarFiles() []string {
+	var asarFiles []string
+	if !install.ReadOnly {
+		for _, asarFile := range CLIENT_ASAR_FILES {
+			asarPath := filepath.Join(install.Resources, asarFile)
+			if _, err := os.Stat(asarPath); err == nil {
+				asarFiles = append(asarFiles, asarPath)
+			}
+		}
+	}
+	return append(asarFiles, install.cachedAsarFiles()...)
+}
+
+func (install ClientInstall) cachedAsarFiles() []string {
+	cached, _ := filepath.Glob(filepath.Join(install.CacheDir, "*.asar"))
+	return cached
+}
+
+func copyFile(source string, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	return writeReplacing(destination, info.Mode(), func(out io.Writer) error {
+		_, err := io.Copy(out, in)
+		return err
+	})
+}
+
+func patchClientAsar(asarPath string, rules PatchRules, serverAddress string) error {
+	backupPath := asarPath + BACKUP_FILE_SUFFIX
+	if _, err := os.Stat(backupPath); err == nil {
+		log.Printf("[*] Restoring original %s from backup", asarPath)
+		if err := copyFile(backupPath, asarPath); err != nil {
+			return err
+		}
+	} else {
+		log.Printf("[*] Backing up original %s", asarPath)
+		if err := copyFile(asarPath, backupPath); err != nil {
+			return err
+		}
+	}
+
+	archive, err := openAsar(asarPath)
+	if err != nil {
+		return err
+	}
+	if _, err := patchAsarArchive(archive, asarPath, rules, serverAddress); err != nil {
+		return err
+	}
+	log.Printf("[*] Patched %s", asarPath)
+	return archive.Save(asarPath)
+}
+
+func restoreClientAsar(asarPath string) error {
+	backupPath := asarPath + BACKUP_FILE_SUFFIX
+	if _, err := os.Stat(backupPath); err != nil {
+		return nil
+	}
+	log.Printf("[*] Restoring %s", asarPath)
+	if err := copyFile(backupPath, asarPath); err != nil {
+		return err
+	}
+	return os.Remove(backupPath)
+}
+
+func stopRunningObsidian(procFolder string) {
+	entries, _ := os.ReadDir(procFolder)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		name, err := os.ReadFile(filepath.Join(procFolder, entry.Name(), "comm"))
+		if err != nil || !strings.HasPrefix(strings.ToLower(strings.TrimSpace(string(name))), OBSIDIAN_PROCESS_PREFIX) {
+			continue
+		}
+		if process, err := os.FindProcess(pid); err == nil {
+			log.Printf("[*] Stopping obsidian (%d)", pid)
+			process.Kill()
+			time.Sleep(3 * time.Second)
+		}
+	}
+}
+
+func patchClientCommand(args []string) error {
+	home, _ := os.UserHomeDir()
+	flags := flag.NewFlagSet("patch-client", flag.ExitOnError)
+	server := flags.String("server", "", "Server address, defaults to patch.serverAddress")
+	root := flags.String("root", "/", "Root of the file system to look for installs in")
+	homeFolder := flags.String("home", home, "Home folder of the obsidian user")
+	resources := flags.String("resources", "", "Patch this resources folder instead of the detected installs")
+	restore := flags.Bool("restore", false, "Restore the backed up asar files")
+	kill := flags.Bool("kill", true, "Stop running obsidian processes first")
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+
+	serverAddress := lo.Ternary(*server != "", *server, config.Patch.ServerAddress)
+	serverAddress = lo.Ternary(serverAddress != "", serverAddress, DEFAULT_SERVER_ADDRESS)
+	if !*restore {
+		log.Printf("[*] Configured server: %s", serverAddress)
+	}
+
+	installs := detectClientInstalls(runtime.GOOS, *root, *homeFolder)
+	if *resources != "" {
+		installs = []ClientInstall{{Kind: "manual", Resources: *resources, CacheDir: underRoot(*root, clientLayouts(runtime.GOOS, *homeFolder)[0].cacheDir)}}
+	}
+	if len(installs) == 0 {
+		return fmt.Errorf("[!] Obsidian is not installed")
+	}
+
+	rules, err := loadPatchRules(config.Patch.Rules)
+	if err != nil {
+		return err
+	}
+	if *kill && runtime.GOOS == "linux" && (*root == "" || *root == "/") {
+		stopRunningObsidian("/proc")
+	}
+
+	failed := false
+	for _, install := range installs {
+		log.Printf("[*] Found %s install: %s", install.Kind, install.Resources)
+		if install.ReadOnly && !*restore {
+			log.Printf("[!] %s is read-only, only the cached asar files in %s are patched", install.Resources, install.CacheDir)
+		}
+		for _, asarPath := range install.asarFiles() {
+			if *restore {
+				err = restoreClientAsar(asarPath)
+			} else {
+				err = patchClientAsar(asarPath, rules, serverAddress)
+			}
+			if err != nil {
+				log.Printf("%v\n\n", err)
+				failed = true
+			}
+		}
+	}
+	if failed {
+		return fmt.Errorf("[!] Some asar files were not patched")
+	}
+	return nil
+}
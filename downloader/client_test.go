@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"downloader/asar"
+)
+
+const TEST_CLIENT_MAIN_JS = `fetch("https://raw.githubusercontent.com/obsidianmd/obsidian-releases/HEAD/desktop-releases.json");
+download("https://github.com/obsidianmd/obsidian-releases/releases/download/v1.6.7/obsidian-1.6.7.asar.gz");
+open("https://releases.obsidian.md/desktop");
+let verifiedHash = hash === expected;
+let verifiedSignature = verify(signature);`
+
+func writeTestAsar(t *testing.T, asarPath string) []byte {
+	t.Helper()
+	archive := asar.New()
+	if err := archive.WriteFile("main.js", []byte(TEST_CLIENT_MAIN_JS)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(asarPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Save(asarPath); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(asarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func readTestMainJs(t *testing.T, asarPath string) string {
+	t.Helper()
+	archive, err := asar.Open(asarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mainJs, err := archive.ReadFile("main.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(mainJs)
+}
+
+func assertFileContent(t *testing.T, filePath string, expected []byte) {
+	t.Helper()
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("%s does not hold the original asar", filePath)
+	}
+}
+
+func assertPatchedFor(t *testing.T, asarPath string, serverAddress string) {
+	t.Helper()
+	mainJs := readTestMainJs(t, asarPath)
+	for _, server := range ORIGINAL_SERVER_ADDRESSES {
+		if strings.Contains(mainJs, server) {
+			t.Errorf("%s still references %s", asarPath, server)
+		}
+	}
+	if strings.Count(mainJs, serverAddress+"/") != len(ORIGINAL_SERVER_ADDRESSES) {
+		t.Errorf("%s is not patched for %s:\n%s", asarPath, serverAddress, mainJs)
+	}
+	for _, check := range []string{"let verifiedHash = true ||  hash", "let verifiedSignature = true ||  verify"} {
+		if !strings.Contains(mainJs, check) {
+			t.Errorf("%s is missing %q:\n%s", asarPath, check, mainJs)
+		}
+	}
+}
+
+func TestPatchClientLayouts(t *testing.T) {
+	rules, err := loadPatchRules("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	home := filepath.Join("/", "home", "user")
+	tests := []struct {
+		goos      string
+		kind      string
+		resources string
+		cacheDir  string
+		readOnly  bool
+	}{
+		{"windows", "windows", "/AppData/Local/Programs/Obsidian/resources", "/AppData/Roaming/obsidian", false},
+		{"linux", "deb", "/opt/Obsidian/resources", "/home/user/.config/obsidian", false},
+		{"linux", "system", "/usr/lib/obsidian", "/home/user/.config/obsidian", false},
+		{"linux", "appimage", "/home/user/Applications/squashfs-root/resources", "/home/user/.config/obsidian", false},
+		{"linux", "flatpak", "/var/lib/flatpak/app/md.obsidian.Obsidian/current/active/files/main/resources", "/home/user/.var/app/md.obsidian.Obsidian/config/obsidian", true},
+		{"linux", "flatpak", "/home/user/.local/share/flatpak/app/md.obsidian.Obsidian/current/active/files/main/resources", "/home/user/.var/app/md.obsidian.Obsidian/config/obsidian", true},
+		{"linux", "snap", "/snap/obsidian/current/resources", "/home/user/snap/obsidian/current/.config/obsidian", true},
+	}
+	for _, test := range tests {
+		t.Run(test.kind+test.resources, func(t *testing.T) {
+			t.Setenv("LOCALAPPDATA", "/AppData/Local")
+			t.Setenv("APPDATA", "/AppData/Roaming")
+			root := t.TempDir()
+			installedAsar := filepath.Join(root, filepath.FromSlash(test.resources), "obsidian.asar")
+			cachedAsar := filepath.Join(root, filepath.FromSlash(test.cacheDir), "obsidian-1.6.7.asar")
+			original := writeTestAsar(t, installedAsar)
+			writeTestAsar(t, cachedAsar)
+
+			installs := detectClientInstalls(test.goos, root, home)
+			if len(installs) != 1 {
+				t.Fatalf("expected one install, found %+v", installs)
+			}
+			install := installs[0]
+			if install.Kind != test.kind || install.ReadOnly != test.readOnly || install.Resources != filepath.Dir(installedAsar) {
+				t.Fatalf("unexpected install %+v", install)
+			}
+
+			expected := []string{cachedAsar}
+			if !test.readOnly {
+				expected = []string{installedAsar, cachedAsar}
+			}
+			asarFiles := install.asarFiles()
+			if strings.Join(asarFiles, "\n") != strings.Join(expected, "\n") {
+				t.Fatalf("expected asar files %v, found %v", expected, asarFiles)
+			}
+
+			for _, serverAddress := range []string{"http://mirror-a/files", "http://mirror-b/files"} {
+				for _, asarPath := range asarFiles {
+					if err := patchClientAsar(asarPath, rules, serverAddress); err != nil {
+						t.Fatal(err)
+					}
+					assertFileContent(t, asarPath+BACKUP_FILE_SUFFIX, original)
+					assertPatchedFor(t, asarPath, serverAddress)
+				}
+			}
+
+			if test.readOnly {
+				assertFileContent(t, installedAsar, original)
+				if _, err := os.Stat(installedAsar + BACKUP_FILE_SUFFIX); err == nil {
+					t.Errorf("read-only %s was backed up", installedAsar)
+				}
+			}
+
+			for _, asarPath := range asarFiles {
+				if err := restoreClientAsar(asarPath); err != nil {
+					t.Fatal(err)
+				}
+				assertFileContent(t, asarPath, original)
+				if _, err := os.Stat(asarPath + BACKUP_FILE_SUFFIX); err == nil {
+					t.Errorf("%s backup was not removed", asarPath)
+				}
+			}
+		})
+	}
+}
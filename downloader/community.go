@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+type CommunityPlugin struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Author      string `json:"author"`
+	Description string `json:"description"`
+	Repo        string `json:"repo"`
+}
+
+type CommunityTheme struct {
+	Name       string   `json:"name"`
+	Author     string   `json:"author"`
+	Repo       string   `json:"repo"`
+	Screenshot string   `json:"screenshot"`
+	Modes      []string `json:"modes"`
+}
+
+type Manifest struct {
+	Id            string `json:"id"`
+	Name          string `json:"name"`
+	Version       string `json:"version"`
+	MinAppVersion string `json:"minAppVersion"`
+	Description   string `json:"description"`
+	Author        string `json:"author"`
+	AuthorUrl     string `json:"authorUrl"`
+}
+
+func readJsonFile(filePath string, out interface{}) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return json.NewDecoder(file).Decode(out)
+}
+
+func readCommunityPlugins(downloadFolder string) ([]CommunityPlugin, error) {
+	var plugins []CommunityPlugin
+	err := readJsonFile(filepath.Join(downloadFolder, OBSIDIAN_GITHUB_PATH, PLUGINS_JSON_FILENAME), &plugins)
+	return plugins, err
+}
+
+// The upstream list also has the plugins the served one filters out
+func readUpstreamCommunityPlugins(downloadFolder string) ([]CommunityPlugin, error) {
+	var plugins []CommunityPlugin
+	err := readJsonFile(upstreamFilePath(filepath.Join(downloadFolder, OBSIDIAN_GITHUB_PATH, PLUGINS_JSON_FILENAME)), &plugins)
+	if os.IsNotExist(err) {
+		return readCommunityPlugins(downloadFolder)
+	}
+	return plugins, err
+}
+
+func readCommunityThemes(downloadFolder string) ([]CommunityTheme, error) {
+	var themes []CommunityTheme
+	err := readJsonFile(filepath.Join(downloadFolder, OBSIDIAN_GITHUB_PATH, THEMES_JSON_FILENAME), &themes)
+	return themes, err
+}
+
+func readUpstreamCommunityThemes(downloadFolder string) ([]CommunityTheme, error) {
+	var themes []CommunityTheme
+	err := readJsonFile(upstreamFilePath(filepath.Join(downloadFolder, OBSIDIAN_GITHUB_PATH, THEMES_JSON_FILENAME)), &themes)
+	if os.IsNotExist(err) {
+		return readCommunityThemes(downloadFolder)
+	}
+	return themes, err
+}
+
+func readManifest(repoFolder string) (Manifest, error) {
+	var manifest Manifest
+	err := readJsonFile(filepath.Join(repoFolder, "manifest.json"), &manifest)
+	return manifest, err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+const CONFIG_FILENAME = "config.json"
+
+type Config struct {
+	DesktopReleases struct {
+		Keep    int      `json:"keep"`
+		Pinned  []string `json:"pinned"`
+		Beta    bool     `json:"beta"`
+		Channel string   `json:"channel"`
+	} `json:"desktopReleases"`
+	Installers struct {
+		Enabled   bool     `json:"enabled"`
+		Platforms []string `json:"platforms"`
+		Arches    []string `json:"arches"`
+	} `json:"installers"`
+	Patch struct {
+		ServerAddress string `json:"serverAddress"`
+		Rules         string `json:"rules"`
+	} `json:"patch"`
+	Scan struct {
+		Rules              string `json:"rules"`
+		QuarantineSeverity string `json:"quarantineSeverity"`
+	} `json:"scan"`
+	Review struct {
+		Enabled   bool     `json:"enabled"`
+		Reviewers []string `json:"reviewers"`
+	} `json:"review"`
+	Themes struct {
+		MirrorAssets    bool  `json:"mirrorAssets"`
+		ThumbnailWidths []int `json:"thumbnailWidths"`
+	} `json:"themes"`
+	Readme struct {
+		MirrorImages bool `json:"mirrorImages"`
+		RenderHtml   bool `json:"renderHtml"`
+	} `json:"readme"`
+	Cdn struct {
+		Enabled        bool     `json:"enabled"`
+		Hosts          []string `json:"hosts"`
+		RewriteScripts bool     `json:"rewriteScripts"`
+	} `json:"cdn"`
+	Offline struct {
+		IgnoredHosts   []string          `json:"ignoredHosts"`
+		Overrides      map[string]string `json:"overrides"`
+		HideOnlineOnly bool              `json:"hideOnlineOnly"`
+	} `json:"offline"`
+	Blobs struct {
+		Enabled bool   `json:"enabled"`
+		Folder  string `json:"folder"`
+	} `json:"blobs"`
+	Gc struct {
+		KeepVersions int                 `json:"keepVersions"`
+		Pinned       map[string][]string `json:"pinned"`
+	} `json:"gc"`
+	RemovedPlugins struct {
+		Action string   `json:"action"`
+		Causes []string `json:"causes"`
+		Allow  []string `json:"allow"`
+	} `json:"removedPlugins"`
+	Server struct {
+		Listen            string            `json:"listen"`
+		WebFolder         string            `json:"webFolder"`
+		IdentityHeader    string            `json:"identityHeader"`
+		TrustedProxies    []string          `json:"trustedProxies"`
+		TrustForwardedFor bool              `json:"trustForwardedFor"`
+		Users             map[string]string `json:"users"`
+	} `json:"server"`
+}
+
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	var c Config
+	c.DesktopReleases.Keep = 3
+	c.DesktopReleases.Beta = true
+	c.DesktopReleases.Channel = "latest"
+	c.Installers.Platforms = []string{"linux", "windows", "macos"}
+	c.Installers.Arches = []string{"x64"}
+	c.Scan.QuarantineSeverity = "high"
+	c.Themes.MirrorAssets = true
+	c.Themes.ThumbnailWidths = []int{480, 1024}
+	c.Readme.MirrorImages = true
+	c.Cdn.Hosts = []string{"cdn.jsdelivr.net", "unpkg.com", "cdnjs.cloudflare.com", "esm.sh"}
+	c.Offline.IgnoredHosts = []string{
+		"github.com", "githubusercontent.com", "obsidian.md", "w3.org", "mozilla.org", "reactjs.org", "react.dev",
+		"json-schema.org", "buymeacoffee.com", "ko-fi.com", "paypal.com", "paypal.me", "patreon.com", "127.0.0.1",
+	}
+	c.Blobs.Folder = filepath.Join(".", "blobs")
+	c.Gc.KeepVersions = 3
+	c.RemovedPlugins.Action = REMOVED_KEEP
+	c.RemovedPlugins.Causes = []string{"malicious", "malware", "security", "vulnerab", "exploit", "privacy", "tracking", "telemetry", "policy", "violat", "abuse"}
+	c.Server.Listen = ":8080"
+	c.Server.WebFolder = filepath.Join("..", "nginx")
+	return c
+}
+
+func loadConfig(configPath string) (Config, error) {
+	c := defaultConfig()
+	file, err := os.Open(configPath)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(&c); err != nil {
+		return c, fmt.Errorf("[!] Error parsing config: %s, %s", configPath, err)
+	}
+	for repo, class := range c.Offline.Overrides {
+		if !lo.Contains(OFFLINE_CLASSES, class) {
+			return c, fmt.Errorf("[!] Unknown offline override for %s: %s, expected one of %s", repo, class, strings.Join(OFFLINE_CLASSES, ", "))
+		}
+	}
+	return c, nil
+}
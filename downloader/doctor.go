@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+var (
+	MIRROR_ENDPOINTS = []string{
+		OBSIDIAN_GITHUB_PATH + "/HEAD/" + PLUGINS_JSON_FILENAME,
+		OBSIDIAN_GITHUB_PATH + "/HEAD/" + THEMES_JSON_FILENAME,
+		OBSIDIAN_GITHUB_PATH + "/HEAD/community-plugin-stats.json",
+		OBSIDIAN_GITHUB_PATH + "/HEAD/" + DESKTOP_RELEASES_FILE,
+		"stats/theme",
+	}
+	CACHED_ASAR_VERSION_REGEX = regexp.MustCompile(`^obsidian-([0-9.]+)\.asar$`)
+	// The patch puts the server address in front of the obsidian releases repo path
+	PATCHED_SERVER_REGEX = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'` + "`" + `]*?)/` + OBSIDIAN_GITHUB_PATH)
+)
+
+type doctorReport struct {
+	problems int
+}
+
+func (report *doctorReport) ok(format string, args ...interface{}) {
+	fmt.Printf("[ok] "+format+"\n", args...)
+}
+
+func (report *doctorReport) problem(fix string, format string, args ...interface{}) {
+	report.problems++
+	fmt.Printf("[!]  "+format+"\n", args...)
+	if fix != "" {
+		fmt.Printf("     -> %s\n", fix)
+	}
+}
+
+func asarVersion(asarPath string) string {
+	if match := CACHED_ASAR_VERSION_REGEX.FindStringSubmatch(filepath.Base(asarPath)); match != nil {
+		return match[1]
+	}
+	archive, err := openAsar(asarPath)
+	if err != nil {
+		return ""
+	}
+	packageJson, err := archive.ReadFile("package.json")
+	if err != nil {
+		return ""
+	}
+	manifest := struct {
+		Version string
+	}{}
+	json.Unmarshal(packageJson, &manifest)
+	return manifest.Version
+}
+
+func inspectClientAsar(report *doctorReport, asarPath string, rules PatchRules, serverAddress string) bool {
+	archive, err := openAsar(asarPath)
+	if err != nil {
+		report.problem("Reinstall obsidian or restore the backup", "%s cannot be read: %s", asarPath, err)
+		return false
+	}
+
+	serverMatches, upstreamMatches := 0, map[string]int{}
+	var patchedServers []string
+	for _, file := range rules.targetFiles() {
+		content, err := archive.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		for _, match := range PATCHED_SERVER_REGEX.FindAllStringSubmatch(string(content), -1) {
+			patchedServers = append(patchedServers, match[1])
+		}
+		serverMatches += strings.Count(string(content), serverAddress)
+		for _, upstream := range ORIGINAL_SERVER_ADDRESSES {
+			upstreamMatches[upstream] += strings.Count(string(content), upstream)
+		}
+	}
+
+	remaining := lo.PickBy(upstreamMatches, func(_ string, count int) bool { return count > 0 })
+	_, backupErr := os.Stat(asarPath + BACKUP_FILE_SUFFIX)
+	switch {
+	case serverMatches == 0 && len(remaining) == 0:
+		patchedServers = lo.Uniq(patchedServers)
+		if len(patchedServers) == 0 {
+			patchedServers = []string{"an unknown server"}
+		}
+		report.problem("Run patch-client with -server "+serverAddress, "%s is patched for another server: %s", asarPath, strings.Join(patchedServers, ", "))
+		return false
+	case serverMatches == 0:
+		report.problem("Run patch-client", "%s is not patched, it still points at %s", asarPath, strings.Join(lo.Keys(remaining), ", "))
+		return false
+	case len(remaining) > 0:
+		report.problem("Check the patch rules with patch-check and run patch-client again", "%s is half patched, it points at %s and still at %s", asarPath, serverAddress, strings.Join(lo.Keys(remaining), ", "))
+		return false
+	}
+
+	report.ok("%s is patched for %s (%d references)", asarPath, serverAddress, serverMatches)
+	if backupErr != nil {
+		report.problem("", "%s has no %s backup, patch-client -restore won't work", asarPath, BACKUP_FILE_SUFFIX)
+	}
+	return true
+}
+
+func inspectClientInstall(report *doctorReport, install ClientInstall, rules PatchRules, serverAddress string) {
+	fmt.Printf("\n%s install: %s\n", install.Kind, install.Resources)
+
+	bundledVersion, bundledPatched := "", true
+	for _, asarFile := range CLIENT_ASAR_FILES {
+		asarPath := filepath.Join(install.Resources, asarFile)
+		if _, err := os.Stat(asarPath); err != nil {
+			continue
+		}
+		patched := inspectClientAsar(report, asarPath, rules, serverAddress)
+		if asarFile == CLIENT_ASAR_FILES[0] {
+			bundledVersion, bundledPatched = asarVersion(asarPath), patched
+		}
+	}
+
+	for _, cachedPath := range install.cachedAsarFiles() {
+		patched := inspectClientAsar(report, cachedPath, rules, serverAddress)
+		cachedVersion := asarVersion(cachedPath)
+		if cachedVersion != "" && compareVersions(cachedVersion, bundledVersion) > 0 {
+			if !patched {
+				report.problem("Run patch-client or delete the cached asar", "cached %s (v%s) overrides the bundled v%s and is not patched", filepath.Base(cachedPath), cachedVersion, bundledVersion)
+			} else if !bundledPatched {
+				report.ok("cached %s (v%s) overrides the unpatched bundled v%s", filepath.Base(cachedPath), cachedVersion, bundledVersion)
+			}
+		}
+	}
+}
+
+func probeMirror(report *doctorReport, serverAddress string) {
+	fmt.Printf("\nmirror: %s\n", serverAddress)
+	client := http.Client{Timeout: 10 * time.Second}
+	for _, endpoint := range MIRROR_ENDPOINTS {
+		url := strings.TrimSuffix(serverAddress, "/") + "/" + endpoint
+		resp, err := client.Get(url)
+		if err != nil {
+			report.problem("Check that the server address resolves and the server is running", "%s is unreachable: %s", url, err)
+			continue
+		}
+
+		var body interface{}
+		decodeErr := json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode != 200:
+			report.problem("Run the downloader and check the server paths", "%s returned %d", url, resp.StatusCode)
+		case decodeErr != nil:
+			report.problem("Check the server content type and the downloaded file", "%s is not valid json: %s", url, decodeErr)
+		default:
+			report.ok("%s", url)
+		}
+	}
+}
+
+func doctorCommand(args []string) error {
+	home, _ := os.UserHomeDir()
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	server := flags.String("server", "", "Server address, defaults to patch.serverAddress")
+	root := flags.String("root", "/", "Root of the file system to look for installs in")
+	homeFolder := flags.String("home", home, "Home folder of the obsidian user")
+	offline := flags.Bool("offline", false, "Don't probe the mirror")
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+
+	serverAddress := lo.Ternary(*server != "", *server, config.Patch.ServerAddress)
+	serverAddress = lo.Ternary(serverAddress != "", serverAddress, DEFAULT_SERVER_ADDRESS)
+	rules, err := loadPatchRules(config.Patch.Rules)
+	if err != nil {
+		return err
+	}
+
+	report := &doctorReport{}
+	installs := detectClientInstalls(runtime.GOOS, *root, *homeFolder)
+	if len(installs) == 0 {
+		report.problem("Install obsidian or pass -root/-home", "No obsidian install found")
+	}
+	for _, install := range installs {
+		inspectClientInstall(report, install, rules, serverAddress)
+	}
+	if !*offline {
+		probeMirror(report, serverAddress)
+	}
+
+	fmt.Println()
+	if report.problems > 0 {
+		return fmt.Errorf("[!] Found %d problems", report.problems)
+	}
+	fmt.Println("[*] No problems found")
+	return nil
+}
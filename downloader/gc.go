@@ -0,0 +1,238 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/vbauerster/mpb/v8/decor"
+)
+
+const (
+	PLUGIN_VERSIONS_FILE = "versions.json"
+	GC_ORPHANED_REPO     = "orphaned repo"
+	GC_SUPERSEDED        = "superseded version"
+	GC_STALE_IMAGE       = "stale image"
+)
+
+type GcCandidate struct {
+	Path   string
+	Reason string
+	Size   int64
+}
+
+func formatSize(size int64) string {
+	return fmt.Sprintf("% .1f", decor.SizeB1024(size))
+}
+
+func newGcCandidate(filePath string, reason string) GcCandidate {
+	size, _ := dirSize(filePath)
+	return GcCandidate{Path: filePath, Reason: reason, Size: size}
+}
+
+// The version Obsidian installs on an app too old for the latest manifest
+// is the newest one versions.json allows
+func compatiblePluginVersion(manifest Manifest, versions map[string]string, appVersion string) string {
+	if compareVersions(manifest.MinAppVersion, appVersion) <= 0 {
+		return manifest.Version
+	}
+	compatible := ""
+	for version, minAppVersion := range versions {
+		if compareVersions(minAppVersion, appVersion) <= 0 && (compatible == "" || compareVersions(version, compatible) > 0) {
+			compatible = version
+		}
+	}
+	return compatible
+}
+
+// Keeps the served version, the newest gc.keepVersions, the versions mirrored
+// desktop releases install and the pinned ones
+func retainedPluginVersions(repo string, repoFolder string, versions []string, appVersions []string) []string {
+	sorted := append([]string{}, versions...)
+	sort.Slice(sorted, func(i, j int) bool { return compareVersions(sorted[i], sorted[j]) > 0 })
+	retained := append([]string{}, sorted[:lo.Clamp(config.Gc.KeepVersions, 0, len(sorted))]...)
+
+	manifest, err := readManifest(repoFolder)
+	if err == nil {
+		retained = append(retained, manifest.Version)
+		var compatible map[string]string
+		readJsonFile(filepath.Join(repoFolder, PLUGIN_VERSIONS_FILE), &compatible)
+		for _, appVersion := range appVersions {
+			retained = append(retained, compatiblePluginVersion(manifest, compatible, appVersion))
+		}
+	}
+	return lo.Uniq(append(retained, config.Gc.Pinned[repo]...))
+}
+
+func supersededPluginVersions(repo string, repoFolder string, appVersions []string) []GcCandidate {
+	releasesFolder := filepath.Join(repoFolder, "releases", "download")
+	entries, _ := os.ReadDir(releasesFolder)
+	versions := lo.FilterMap(entries, func(entry os.DirEntry, _ int) (string, bool) { return entry.Name(), entry.IsDir() })
+
+	retained := retainedPluginVersions(repo, repoFolder, versions, appVersions)
+	var candidates []GcCandidate
+	for _, version := range lo.Without(versions, retained...) {
+		candidates = append(candidates, newGcCandidate(filepath.Join(releasesFolder, version), GC_SUPERSEDED))
+	}
+	return candidates
+}
+
+func readmeImagePaths(repo string, repoFolder string) []string {
+	readme, err := os.ReadFile(filepath.Join(repoFolder, README_FILE+ORIGINAL_FILE_SUFFIX))
+	if err != nil {
+		if readme, err = os.ReadFile(filepath.Join(repoFolder, README_FILE)); err != nil {
+			return nil
+		}
+	}
+	var paths []string
+	for _, regex := range []*regexp.Regexp{MARKDOWN_IMAGE_REGEX, HTML_IMAGE_REGEX} {
+		for _, match := range regex.FindAllStringSubmatch(string(readme), -1) {
+			if _, localPath, ok := readmeImageLocation(repo, repoFolder, match[2]); ok && localPath != "" {
+				paths = append(paths, localPath)
+			}
+		}
+	}
+	return paths
+}
+
+// Screenshots and README images are stored at their path in the repo, so
+// renamed ones stay behind. Only images fetched from the repo are looked at
+func staleRepoImages(repo string, repoFolder string, screenshots []string) []GcCandidate {
+	referenced := readmeImagePaths(repo, repoFolder)
+	for _, screenshot := range screenshots {
+		referenced = append(referenced, filepath.Join(repoFolder, screenshot))
+	}
+	repoUrl := fmt.Sprintf("https://raw.githubusercontent.com/%s/", repo)
+
+	var candidates []GcCandidate
+	for name, record := range readProvenance(repoFolder) {
+		filePath := filepath.Join(repoFolder, filepath.FromSlash(name))
+		if !strings.HasPrefix(record.Url, repoUrl) || strings.HasPrefix(name, "releases/") ||
+			!lo.Contains(README_IMAGE_EXTENSIONS, strings.ToLower(filepath.Ext(name))) || lo.Contains(referenced, filePath) {
+			continue
+		}
+		if _, err := os.Stat(filePath); err == nil {
+			candidates = append(candidates, newGcCandidate(filePath, GC_STALE_IMAGE))
+		}
+	}
+	return candidates
+}
+
+// Repo folders are <owner>/<repo> with a manifest, which leaves the obsidian
+// releases, cdn and stats folders out
+func orphanedRepos(downloadFolder string, repos []string) []GcCandidate {
+	folders, _ := filepath.Glob(filepath.Join(downloadFolder, "*", "*", "manifest.json"))
+	var candidates []GcCandidate
+	for _, manifestPath := range folders {
+		repoFolder := filepath.Dir(manifestPath)
+		relativePath, _ := filepath.Rel(downloadFolder, repoFolder)
+		repo := filepath.ToSlash(relativePath)
+		if repo == OBSIDIAN_GITHUB_PATH || strings.HasPrefix(repoFolder, CDN_FOLDER+string(filepath.Separator)) || lo.Contains(repos, repo) {
+			continue
+		}
+		candidates = append(candidates, newGcCandidate(repoFolder, GC_ORPHANED_REPO))
+	}
+	return candidates
+}
+
+func collectGarbage(downloadFolder string) ([]GcCandidate, error) {
+	plugins, err := readUpstreamCommunityPlugins(downloadFolder)
+	if err != nil {
+		return nil, err
+	}
+	themes, err := readUpstreamCommunityThemes(downloadFolder)
+	if err != nil {
+		return nil, err
+	}
+	// An unreadable list would make every repo look orphaned
+	if len(plugins) == 0 || len(themes) == 0 {
+		return nil, fmt.Errorf("[!] Community lists are empty, run sync first")
+	}
+	removed, err := removedPlugins(downloadFolder)
+	if err != nil {
+		return nil, err
+	}
+	kept := lo.FilterMap(removed, func(plugin RemovedPlugin, _ int) (string, bool) {
+		return plugin.Repo, removedPluginAction(plugin) == REMOVED_KEEP
+	})
+	pluginRepos := append(lo.Map(plugins, func(plugin CommunityPlugin, _ int) string { return plugin.Repo }), kept...)
+	screenshots := map[string][]string{}
+	for _, theme := range themes {
+		screenshots[theme.Repo] = append(screenshots[theme.Repo], theme.Screenshot)
+	}
+
+	repos := lo.Uniq(append(append([]string{}, pluginRepos...), lo.Keys(screenshots)...))
+
+	candidates := orphanedRepos(downloadFolder, repos)
+	appVersions := mirroredDesktopVersions(downloadFolder)
+	for _, repo := range lo.Uniq(pluginRepos) {
+		candidates = append(candidates, supersededPluginVersions(repo, filepath.Join(downloadFolder, repo), appVersions)...)
+	}
+	for _, repo := range repos {
+		candidates = append(candidates, staleRepoImages(repo, filepath.Join(downloadFolder, repo), screenshots[repo])...)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Path < candidates[j].Path })
+	return candidates, nil
+}
+
+func removeGarbage(candidates []GcCandidate) (int64, error) {
+	var reclaimed int64
+	for _, candidate := range candidates {
+		if err := os.RemoveAll(candidate.Path); err != nil {
+			return reclaimed, err
+		}
+		reclaimed += candidate.Size
+		if candidate.Reason == GC_ORPHANED_REPO {
+			os.Remove(filepath.Dir(candidate.Path))
+		} else {
+			provenance.prune(candidate.Path)
+		}
+	}
+	return reclaimed, nil
+}
+
+func gcCommand(args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "List what would be removed without removing it")
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+
+	candidates, err := collectGarbage(DOWNLOAD_FOLDER)
+	if err != nil {
+		return err
+	}
+	total := lo.SumBy(candidates, func(candidate GcCandidate) int64 { return candidate.Size })
+	for _, candidate := range candidates {
+		fmt.Printf("%-18s %10s  %s\n", candidate.Reason, formatSize(candidate.Size), candidate.Path)
+	}
+	if *dryRun {
+		log.Printf("[*] Would reclaim %s from %d files and folders", formatSize(total), len(candidates))
+		return nil
+	}
+
+	reclaimed, err := removeGarbage(candidates)
+	if err != nil {
+		return err
+	}
+	if err := provenance.flush(); err != nil {
+		return err
+	}
+	if err := writeMirrorIndex(DOWNLOAD_FOLDER); err != nil {
+		return err
+	}
+	if _, err := storeMirrorBlobs(DOWNLOAD_FOLDER); err != nil {
+		return err
+	}
+	if _, err := writeSearchIndex(DOWNLOAD_FOLDER, SEARCH_INDEX_FILE); err != nil {
+		return err
+	}
+	log.Printf("[*] Reclaimed %s from %d files and folders", formatSize(reclaimed), len(candidates))
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const GITHUB_API_URL = "https://api.github.com"
+
+type GithubReleaseAsset struct {
+	Name               string `json:"name"`
+	Size               int64  `json:"size"`
+	Digest             string `json:"digest"`
+	BrowserDownloadUrl string `json:"browser_download_url"`
+}
+
+type GithubRelease struct {
+	TagName     string               `json:"tag_name"`
+	Name        string               `json:"name"`
+	Body        string               `json:"body"`
+	HtmlUrl     string               `json:"html_url"`
+	PublishedAt time.Time            `json:"published_at"`
+	Assets      []GithubReleaseAsset `json:"assets"`
+}
+
+// Set once the api refuses more requests, later calls fail without asking again
+var githubRateLimited atomic.Bool
+
+func getGithubApi(apiPath string, out interface{}) error {
+	if githubRateLimited.Load() {
+		return fmt.Errorf("[!] Github api rate limit exceeded, skipped %s", apiPath)
+	}
+	req, err := http.NewRequest(http.MethodGet, GITHUB_API_URL+apiPath, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) && resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		githubRateLimited.Store(true)
+		return fmt.Errorf("[!] Github api rate limit exceeded, set GITHUB_TOKEN to raise it: %s", apiPath)
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("[!] Github api returned %d for %s", resp.StatusCode, apiPath)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func getGithubRelease(repoUrlPath string, tag string) (GithubRelease, error) {
+	var release GithubRelease
+	err := getGithubApi(fmt.Sprintf("/repos/%s/releases/tags/%s", repoUrlPath, tag), &release)
+	return release, err
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+const (
+	INSTALLERS_INFO_FILE = "installers.json"
+	DOWNLOADS_FILE       = "downloads.json"
+	CHECKSUMS_FILE       = "SHA256SUMS"
+)
+
+var INSTALLER_PLATFORMS = map[string]string{
+	".AppImage": "linux",
+	".deb":      "linux",
+	".tar.gz":   "linux",
+	".exe":      "windows",
+	".dmg":      "macos",
+}
+
+type Installer struct {
+	Name     string `json:"name"`
+	Platform string `json:"platform"`
+	Arch     string `json:"arch"`
+	Size     int64  `json:"size"`
+	Sha256   string `json:"sha256"`
+}
+
+type Download struct {
+	Installer
+	Version string `json:"version"`
+	IsBeta  bool   `json:"isBeta,omitempty"`
+	Path    string `json:"path"`
+}
+
+func classifyInstaller(name string) (string, string, bool) {
+	if strings.HasSuffix(name, ".asar.gz") {
+		return "", "", false
+	}
+
+	platform := ""
+	for extension, extensionPlatform := range INSTALLER_PLATFORMS {
+		if strings.HasSuffix(name, extension) {
+			platform = extensionPlatform
+		}
+	}
+	if platform == "" {
+		return "", "", false
+	}
+
+	lowerName := strings.ToLower(name)
+	switch {
+	case strings.Contains(lowerName, "arm64"), strings.Contains(lowerName, "aarch64"):
+		return platform, "arm64", true
+	case strings.Contains(lowerName, "ia32"):
+		return platform, "ia32", true
+	case platform == "linux" || strings.Contains(lowerName, "x64") || strings.Contains(lowerName, "amd64"):
+		return platform, "x64", true
+	}
+	return platform, "universal", true
+}
+
+func fileSha256(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Installers are 100+ MB, they are streamed to disk and hashed on the way
+func downloadInstaller(installerUrl string, installerPath string) (string, error) {
+	resp, err := http.Get(installerUrl)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("[!] Unexpected status: %s", resp.Status)
+	}
+	if err := os.MkdirAll(filepath.Dir(installerPath), 0755); err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	var size int64
+	if err := writeReplacing(installerPath, 0644, func(out io.Writer) (err error) {
+		size, err = io.Copy(io.MultiWriter(out, hash), resp.Body)
+		return err
+	}); err != nil {
+		return "", err
+	}
+	sha := hex.EncodeToString(hash.Sum(nil))
+	provenance.recordResponse(installerUrl, installerPath, resp, sha, size)
+	return sha, nil
+}
+
+func downloadDesktopInstallers(downloadFolder string, version string) error {
+	release, err := getGithubRelease(OBSIDIAN_GITHUB_PATH, "v"+version)
+	if err != nil {
+		return fmt.Errorf("[!] Error getting desktop release assets: %s, %s", version, err)
+	}
+
+	var installers []Installer
+	releaseFolder := desktopReleaseFolder(downloadFolder, version)
+	for _, asset := range release.Assets {
+		platform, arch, ok := classifyInstaller(asset.Name)
+		if !ok || !lo.Contains(config.Installers.Platforms, platform) {
+			continue
+		}
+		if arch != "universal" && !lo.Contains(config.Installers.Arches, arch) {
+			continue
+		}
+
+		installerPath := filepath.Join(releaseFolder, asset.Name)
+		var sha string
+		if info, statErr := os.Stat(installerPath); statErr != nil || info.Size() != asset.Size {
+			sha, err = downloadInstaller(asset.BrowserDownloadUrl, installerPath)
+		} else {
+			sha, err = fileSha256(installerPath)
+		}
+		if err != nil {
+			log.Printf("[!] Error downloading installer: %s, %s\n\n", asset.Name, err)
+			continue
+		}
+		if expected := strings.TrimPrefix(asset.Digest, "sha256:"); expected != "" && expected != sha {
+			os.Remove(installerPath)
+			log.Printf("[!] Checksum mismatch for installer: %s, expected %s got %s\n\n", asset.Name, expected, sha)
+			continue
+		}
+
+		installers = append(installers, Installer{
+			Name:     asset.Name,
+			Platform: platform,
+			Arch:     arch,
+			Size:     asset.Size,
+			Sha256:   sha,
+		})
+	}
+
+	checksums := lo.Map(installers, func(installer Installer, _ int) string {
+		return fmt.Sprintf("%s  %s\n", installer.Sha256, installer.Name)
+	})
+	if err := replaceFile(filepath.Join(releaseFolder, CHECKSUMS_FILE), []byte(strings.Join(checksums, "")), 0644); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(installers, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(filepath.Join(releaseFolder, INSTALLERS_INFO_FILE), data, 0644)
+}
+
+func readDesktopInstallers(downloadFolder string, version string) []Installer {
+	var installers []Installer
+	data, err := os.ReadFile(filepath.Join(desktopReleaseFolder(downloadFolder, version), INSTALLERS_INFO_FILE))
+	if err == nil {
+		json.Unmarshal(data, &installers)
+	}
+	return installers
+}
+
+func writeDownloadsList(downloadFolder string) error {
+	downloads := []Download{}
+	for _, release := range listMirroredDesktopReleases(downloadFolder) {
+		for _, installer := range readDesktopInstallers(downloadFolder, release.LatestVersion) {
+			downloads = append(downloads, Download{
+				Installer: installer,
+				Version:   release.LatestVersion,
+				IsBeta:    release.IsBeta,
+				Path:      fmt.Sprintf("%s/releases/download/v%s/%s", OBSIDIAN_GITHUB_PATH, release.LatestVersion, installer.Name),
+			})
+		}
+	}
+
+	data, err := json.MarshalIndent(downloads, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(filepath.Join(downloadFolder, DOWNLOADS_FILE), data, 0644)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+const (
+	LICENSE_NONE         = "NONE"
+	LICENSE_UNRECOGNIZED = "NOASSERTION"
+)
+
+var (
+	// Raw github urls are case sensitive, so the common spellings are all tried
+	LICENSE_FILES = []string{
+		"LICENSE", "LICENSE.md", "LICENSE.txt", "LICENSE.MD", "License", "License.md", "License.txt", "license", "license.md", "license.txt",
+		"LICENCE", "LICENCE.md", "LICENCE.txt", "COPYING", "COPYING.md", "COPYING.txt",
+	}
+	SPDX_IDENTIFIER_REGEX   = regexp.MustCompile(`SPDX-License-Identifier:\s*([A-Za-z0-9.+-]+)`)
+	COPYLEFT_LICENSE_PREFIX = []string{"GPL-", "AGPL-", "LGPL-", "MPL-", "EPL-", "EUPL-", "CC-BY-SA-", "OSL-"}
+	LICENSE_PATTERNS        = []struct {
+		Id    string
+		Regex *regexp.Regexp
+	}{
+		{"AGPL-3.0", regexp.MustCompile(`(?i)GNU AFFERO GENERAL PUBLIC LICENSE\s+Version 3`)},
+		{"LGPL-3.0", regexp.MustCompile(`(?i)GNU LESSER GENERAL PUBLIC LICENSE\s+Version 3`)},
+		{"LGPL-2.1", regexp.MustCompile(`(?i)GNU LESSER GENERAL PUBLIC LICENSE\s+Version 2\.1`)},
+		{"GPL-3.0", regexp.MustCompile(`(?i)GNU GENERAL PUBLIC LICENSE\s+Version 3`)},
+		{"GPL-2.0", regexp.MustCompile(`(?i)GNU GENERAL PUBLIC LICENSE\s+Version 2`)},
+		{"MPL-2.0", regexp.MustCompile(`(?i)Mozilla Public License,?\s+(Version|v\.)\s*2\.0`)},
+		{"EUPL-1.2", regexp.MustCompile(`(?i)European Union Public Licen[cs]e\s+v\.?\s*1\.2`)},
+		{"Apache-2.0", regexp.MustCompile(`(?i)Apache License,?\s+Version 2\.0`)},
+		{"CC-BY-SA-4.0", regexp.MustCompile(`(?i)Attribution-ShareAlike 4\.0 International`)},
+		{"CC-BY-NC-4.0", regexp.MustCompile(`(?i)Attribution-NonCommercial 4\.0 International`)},
+		{"CC-BY-4.0", regexp.MustCompile(`(?i)Attribution 4\.0 International`)},
+		{"CC0-1.0", regexp.MustCompile(`(?i)CC0 1\.0 Universal`)},
+		{"Unlicense", regexp.MustCompile(`(?i)This is free and unencumbered software released into the public domain`)},
+		{"WTFPL", regexp.MustCompile(`(?i)DO WHAT THE FUCK YOU WANT TO PUBLIC LICENSE`)},
+		{"BSD-3-Clause", regexp.MustCompile(`(?is)Redistribution and use in source and binary forms.*Neither the name`)},
+		{"BSD-2-Clause", regexp.MustCompile(`(?i)Redistribution and use in source and binary forms`)},
+		{"ISC", regexp.MustCompile(`(?is)Permission to use, copy, modify, and(/or)? distribute this software for any\s+purpose with or without fee is hereby granted, provided that`)},
+		{"0BSD", regexp.MustCompile(`(?i)Permission to use, copy, modify, and(/or)? distribute this software for any\s+purpose with or without fee is hereby granted`)},
+		{"MIT", regexp.MustCompile(`(?i)\bMIT License\b|Permission is hereby granted, free of charge`)},
+	}
+)
+
+type LicenseEntry struct {
+	Kind        string `json:"kind"`
+	Name        string `json:"name"`
+	Repo        string `json:"repo"`
+	License     string `json:"license"`
+	LicenseFile string `json:"licenseFile,omitempty"`
+	Review      string `json:"review,omitempty"`
+}
+
+func detectLicense(text string) string {
+	if match := SPDX_IDENTIFIER_REGEX.FindStringSubmatch(text); match != nil {
+		return match[1]
+	}
+	for _, pattern := range LICENSE_PATTERNS {
+		if pattern.Regex.MatchString(text) {
+			return pattern.Id
+		}
+	}
+	return LICENSE_UNRECOGNIZED
+}
+
+func isCopyleftLicense(license string) bool {
+	return lo.SomeBy(COPYLEFT_LICENSE_PREFIX, func(prefix string) bool { return strings.HasPrefix(license, prefix) })
+}
+
+// Licence files are matched case-insensitively, in the order of LICENSE_FILES
+func repoLicenseFile(repoFolder string) (string, string) {
+	entries, _ := os.ReadDir(repoFolder)
+	for _, licenseFile := range LICENSE_FILES {
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() || !strings.EqualFold(entry.Name(), licenseFile) {
+				continue
+			}
+			if text, err := os.ReadFile(filepath.Join(repoFolder, entry.Name())); err == nil {
+				return entry.Name(), detectLicense(string(text))
+			}
+		}
+	}
+	return "", LICENSE_NONE
+}
+
+func repoLicense(repoFolder string) string {
+	_, license := repoLicenseFile(repoFolder)
+	if license == LICENSE_NONE || license == LICENSE_UNRECOGNIZED {
+		return ""
+	}
+	return license
+}
+
+func licenseReview(license string) string {
+	switch {
+	case license == LICENSE_NONE:
+		return "no licence"
+	case license == LICENSE_UNRECOGNIZED:
+		return "unrecognized licence"
+	case isCopyleftLicense(license):
+		return "copyleft"
+	}
+	return ""
+}
+
+func collectLicenses(downloadFolder string) ([]LicenseEntry, error) {
+	var entries []LicenseEntry
+	add := func(kind string, name string, repo string) {
+		licenseFile, license := repoLicenseFile(filepath.Join(downloadFolder, repo))
+		entries = append(entries, LicenseEntry{
+			Kind:        kind,
+			Name:        name,
+			Repo:        repo,
+			License:     license,
+			LicenseFile: licenseFile,
+			Review:      licenseReview(license),
+		})
+	}
+
+	plugins, err := readCommunityPlugins(downloadFolder)
+	if err != nil {
+		return nil, fmt.Errorf("[!] Error reading plugins list, run sync first: %s", err)
+	}
+	for _, plugin := range plugins {
+		add("plugin", plugin.Id, plugin.Repo)
+	}
+
+	themes, err := readCommunityThemes(downloadFolder)
+	if err != nil {
+		return nil, fmt.Errorf("[!] Error reading themes list, run sync first: %s", err)
+	}
+	for _, theme := range themes {
+		add("theme", theme.Name, theme.Repo)
+	}
+	return entries, nil
+}
+
+func writeLicenseReport(out io.Writer, entries []LicenseEntry) {
+	groups := lo.GroupBy(entries, func(entry LicenseEntry) string { return entry.License })
+	licenses := lo.Keys(groups)
+	sort.Slice(licenses, func(i, j int) bool {
+		if len(groups[licenses[i]]) != len(groups[licenses[j]]) {
+			return len(groups[licenses[i]]) > len(groups[licenses[j]])
+		}
+		return licenses[i] < licenses[j]
+	})
+
+	fmt.Fprintln(out, "# Licence report")
+	fmt.Fprintln(out)
+	for _, license := range licenses {
+		fmt.Fprintf(out, "- %s: %d\n", license, len(groups[license]))
+	}
+
+	review := lo.Filter(entries, func(entry LicenseEntry, _ int) bool { return entry.Review != "" })
+	fmt.Fprintf(out, "\n## Needs legal review (%d)\n\n", len(review))
+	for _, entry := range review {
+		fmt.Fprintf(out, "- [%s] %s %s (%s): %s\n", entry.Review, entry.Kind, entry.Name, entry.Repo, entry.License)
+	}
+
+	for _, license := range licenses {
+		fmt.Fprintf(out, "\n## %s\n\n", license)
+		for _, entry := range groups[license] {
+			fmt.Fprintf(out, "- %s %s (%s)\n", entry.Kind, entry.Name, entry.Repo)
+		}
+	}
+}
+
+func licensesCommand(args []string) error {
+	flags := flag.NewFlagSet("licenses", flag.ExitOnError)
+	format := flags.String("format", "markdown", "Report format, markdown or json")
+	output := flags.String("o", "-", "Output file, - for stdout")
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+
+	entries, err := collectLicenses(DOWNLOAD_FOLDER)
+	if err != nil {
+		return err
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+	writeLicenseReport(out, entries)
+	return nil
+}
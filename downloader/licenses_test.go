@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepoLicenseFile(t *testing.T) {
+	const mit = "MIT License\n\nPermission is hereby granted, free of charge"
+	for _, test := range []struct {
+		name    string
+		files   map[string]string
+		file    string
+		license string
+	}{
+		{name: "no licence", files: map[string]string{"README.md": "readme"}, license: LICENSE_NONE},
+		{name: "lower case", files: map[string]string{"license": mit}, file: "license", license: "MIT"},
+		{name: "title case markdown", files: map[string]string{"License.md": mit}, file: "License.md", license: "MIT"},
+		{name: "upper case extension", files: map[string]string{"LICENSE.MD": mit}, file: "LICENSE.MD", license: "MIT"},
+		{name: "copying markdown", files: map[string]string{"COPYING.md": "GNU GENERAL PUBLIC LICENSE\nVersion 3"}, file: "COPYING.md", license: "GPL-3.0"},
+		{name: "licence markdown", files: map[string]string{"LICENCE.md": "Apache License, Version 2.0"}, file: "LICENCE.md", license: "Apache-2.0"},
+		{name: "unknown casing", files: map[string]string{"LiCeNsE.TxT": "custom terms"}, file: "LiCeNsE.TxT", license: LICENSE_UNRECOGNIZED},
+		{name: "license before copying", files: map[string]string{"COPYING": "custom terms", "license.txt": mit}, file: "license.txt", license: "MIT"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			repoFolder := t.TempDir()
+			for name, content := range test.files {
+				if err := os.WriteFile(filepath.Join(repoFolder, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			file, license := repoLicenseFile(repoFolder)
+			if file != test.file || license != test.license {
+				t.Errorf("got %q %q, want %q %q", file, license, test.file, test.license)
+			}
+		})
+	}
+}
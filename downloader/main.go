@@ -1,13 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -27,8 +31,9 @@ const (
 
 var (
 	PLUGIN_RELEASE_FILES = []string{"manifest.json", "styles.css", "main.js"}
-	PLUGIN_FILES         = []string{"manifest.json", "README.md"}
-	THEMES_FILES         = []string{"manifest.json", "README.md", "theme.css", "obsidian.css"}
+	PLUGIN_FILES         = append([]string{"manifest.json", "README.md", PLUGIN_VERSIONS_FILE}, LICENSE_FILES...)
+	THEMES_FILES         = append([]string{"manifest.json", "README.md", "theme.css", "obsidian.css"}, LICENSE_FILES...)
+	DOWNLOAD_FOLDER      = filepath.Join(".", "files")
 )
 
 type Repo struct {
@@ -58,6 +63,9 @@ func updateLocalGitRepo(repoFolder string, repoUrlPath string) error {
 		if err != nil {
 			return fmt.Errorf("[!] Error getting worktree: %s, %s", repoUrlPath, err)
 		}
+		if err := worktree.Reset(&git.ResetOptions{Mode: git.HardReset}); err != nil {
+			return fmt.Errorf("[!] Error resetting worktree: %s, %s", repoUrlPath, err)
+		}
 		if err := worktree.Pull(&git.PullOptions{}); err != nil && err != git.NoErrAlreadyUpToDate {
 			return fmt.Errorf("[!] Error pulling changes: %s, %s", repoUrlPath, err)
 		}
@@ -65,83 +73,59 @@ func updateLocalGitRepo(repoFolder string, repoUrlPath string) error {
 	return nil
 }
 
-func getOrCreateFile(filePath string) (*os.File, os.FileInfo, error) {
-	var err error
-	fileDir := filepath.Dir(filePath)
-	if _, err = os.Stat(fileDir); os.IsNotExist(err) {
-		if err = os.MkdirAll(fileDir, os.ModeDir); err != nil {
-			return nil, nil, err
-		}
-	}
-	if err != nil {
-		return nil, nil, err
-	}
-
-	var out *os.File
-	var outInfo os.FileInfo
-	if outInfo, err = os.Stat(filePath); os.IsNotExist(err) {
-		if out, err = os.Create(filePath); err == nil {
-			outInfo, _ = out.Stat()
-		}
-	} else {
-		out, err = os.OpenFile(filePath, os.O_RDWR, os.ModeType)
-	}
-	if err != nil {
-		return nil, nil, err
-	}
-	return out, outInfo, nil
-}
-
-func downloadFileIfChanged(fileUrl string, filePath string) {
+func downloadFileIfChanged(fileUrl string, filePath string) bool {
 	var err error
 	resp, err := http.Get(fileUrl)
 	if err != nil {
 		log.Printf("%v\n\n", err)
-		return
+		return false
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Printf("%v\n\n", err)
-		return
+		return false
 	}
 
 	bodySize := int64(len(body))
 	if resp.StatusCode != 200 || bodySize == 0 {
-		return
+		return false
 	}
 
-	out, outInfo, err := getOrCreateFile(filePath)
-	if err != nil {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
 		log.Printf("%v\n\n", err)
-		return
+		return false
 	}
-	defer out.Close()
-	if outInfo.Size() == bodySize {
-		return
+	// Same-size files are compared, the recorded sha has to be the one on disk
+	if info, err := os.Stat(filePath); err == nil && info.Size() == bodySize {
+		if existing, err := os.ReadFile(filePath); err == nil && bytes.Equal(existing, body) {
+			provenance.recordDownload(fileUrl, filePath, resp, body)
+			return false
+		}
 	}
 
-	_, err = out.Write(body)
-	if err != nil {
+	if err := replaceFile(filePath, body, 0644); err != nil {
 		log.Printf("%v\n\n", err)
-		return
+		return false
 	}
+	provenance.recordDownload(fileUrl, filePath, resp, body)
+	return true
 }
 
-func downloadLatestPluginRelease(pluginFolder string, pluginUrlPath string) error {
+func downloadLatestPluginRelease(pluginFolder string, pluginUrlPath string) (string, string, error) {
 	resp, err := http.Get(fmt.Sprintf("https://github.com/%s/releases", pluginUrlPath))
 	if err != nil {
-		return err
+		return "", "", err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil
+		return "", "", nil
 	}
 
 	file, err := os.Open(filepath.Join(pluginFolder, "manifest.json"))
 	if err != nil {
-		return err
+		return "", "", err
 	}
 	defer file.Close()
 
@@ -149,42 +133,115 @@ func downloadLatestPluginRelease(pluginFolder string, pluginUrlPath string) erro
 		Version string
 	}{}
 	if err = json.NewDecoder(file).Decode(&manifest); err != nil {
-		return err
+		return "", "", err
 	}
 
-	var releaseFolder = filepath.Join(pluginFolder, "releases", "download", manifest.Version)
+	// Releases are staged in quarantine until they pass the scan, a mirrored
+	// version starts from its served files so a republished build shows up
+	releaseFolder := quarantineFolder(pluginUrlPath, manifest.Version)
+	if err := stagePluginRelease(pluginReleaseFolder(pluginFolder, manifest.Version), releaseFolder); err != nil {
+		return "", "", err
+	}
 	var wg sync.WaitGroup
 	for _, releaseFile := range PLUGIN_RELEASE_FILES {
 		wg.Add(1)
 		go func(releaseFile string) {
 			defer wg.Done()
+			localFile := releaseFile
+			if releaseFile == PLUGIN_SCRIPT_FILE && rewritesPluginScripts() {
+				localFile += ORIGINAL_FILE_SUFFIX
+			}
 			downloadFileIfChanged(
-				fmt.Sprintf("https://github.com/%s/releases/download/%s/%s", pluginUrlPath, manifest.Version, releaseFile),
-				filepath.Join(releaseFolder, releaseFile),
+				pluginReleaseUrl(pluginUrlPath, manifest.Version, releaseFile),
+				filepath.Join(releaseFolder, localFile),
 			)
 		}(releaseFile)
 	}
 	wg.Wait()
-	return nil
+	return manifest.Version, releaseFolder, nil
+}
+
+func pluginReleaseFolder(pluginFolder string, version string) string {
+	return filepath.Join(pluginFolder, "releases", "download", version)
 }
 
+func pluginReleaseUrl(pluginUrlPath string, version string, releaseFile string) string {
+	return fmt.Sprintf("https://github.com/%s/releases/download/%s/%s", pluginUrlPath, version, releaseFile)
+}
+
+// Files rewritten for the mirror are downloaded next to the served copy
+func downloadedFileName(file string) string {
+	if mirrorsThemeAssets() && lo.Contains(THEME_CSS_FILES, file) || mirrorsReadmeImages() && file == README_FILE {
+		return file + ORIGINAL_FILE_SUFFIX
+	}
+	return file
+}
+
+func downloadedFileNames(files []string) []string {
+	return lo.Map(files, func(file string, _ int) string { return downloadedFileName(file) })
+}
+
+// Licence spellings are tried until one is mirrored
 func downloadFilesFromGithub(repo Repo, folder string, files []string) {
+	licensed := false
 	for _, file := range append(files, repo.extraFiles...) {
+		isLicense := lo.Contains(LICENSE_FILES, file)
+		if isLicense && licensed {
+			continue
+		}
+		filePath := filepath.Join(folder, downloadedFileName(file))
 		downloadFileIfChanged(
 			fmt.Sprintf("https://raw.githubusercontent.com/%s/HEAD/%s", repo.Repo, file),
-			filepath.Join(folder, file),
+			filePath,
 		)
+		if _, err := os.Stat(filePath); err == nil && isLicense {
+			licensed = true
+		}
 	}
 }
 
 func updateRepo(repoFolder string, repo Repo) error {
 	if repo.isPlugin {
+		previousManifest, _ := os.ReadFile(filepath.Join(repoFolder, "manifest.json"))
 		downloadFilesFromGithub(repo, repoFolder, PLUGIN_FILES)
-		if err := downloadLatestPluginRelease(repoFolder, repo.Repo); err != nil {
+		if err := mirrorReadme(repo, repoFolder); err != nil {
+			return fmt.Errorf("[!] Error mirroring readme: %s, %s", repo.Repo, err)
+		}
+		version, releaseFolder, err := downloadLatestPluginRelease(repoFolder, repo.Repo)
+		if err != nil {
 			return fmt.Errorf("[!] Error downloading latest release: %s, %s", repo.Repo, err)
 		}
+		if version != "" {
+			mirrorReleaseNotes(repo, repoFolder, version, releaseFolder)
+			if err := scanPluginRelease(repo, repoFolder, version, releaseFolder, previousManifest); err != nil {
+				return fmt.Errorf("[!] Error scanning latest release: %s, %s", repo.Repo, err)
+			}
+		}
+		cdnUrls, err := mirrorPluginCdn(repo, repoFolder)
+		if err != nil {
+			return fmt.Errorf("[!] Error mirroring cdn resources: %s, %s", repo.Repo, err)
+		}
+		if len(cdnUrls) > 0 {
+			syncReport.update(repo.Repo, "plugin", func(report *RepoReport) { report.Cdn = cdnUrls })
+		}
+		if classification, err := classifyPluginOffline(repoFolder, repo.Repo); err == nil {
+			syncReport.update(repo.Repo, "plugin", func(report *RepoReport) { report.Offline = &classification })
+		}
 	} else if repo.isTheme {
+		previousFiles := readRepoFiles(repoFolder, downloadedFileNames(THEMES_FILES))
 		downloadFilesFromGithub(repo, repoFolder, THEMES_FILES)
+		if err := reviewThemeVersion(repo, repoFolder, previousFiles); err != nil {
+			return fmt.Errorf("[!] Error queueing theme for review: %s, %s", repo.Repo, err)
+		}
+		if err := mirrorThemeAssets(repo, repoFolder); err != nil {
+			return fmt.Errorf("[!] Error mirroring theme assets: %s, %s", repo.Repo, err)
+		}
+		if err := mirrorReadme(repo, repoFolder); err != nil {
+			return fmt.Errorf("[!] Error mirroring readme: %s, %s", repo.Repo, err)
+		}
+		if err := writeThemeThumbnails(repoFolder, repo.extraFiles); err != nil {
+			return fmt.Errorf("[!] Error generating thumbnails: %s, %s", repo.Repo, err)
+		}
 	} else {
 		return fmt.Errorf("[!] Repo: %s is not a plugin nor a theme", repo.Repo)
 	}
@@ -301,42 +358,154 @@ func downloadThemesStats(downloadFolder string) {
 	downloadFileIfChanged("https://releases.obsidian.md/stats/theme", filepath.Join(downloadFolder, "stats", "theme"))
 }
 
-func downloadLatestDesktopRelease(downloadFolder string) {
-	releasesFile, _ := os.Open(filepath.Join(downloadFolder, OBSIDIAN_GITHUB_PATH, DESKTOP_RELEASES_FILE))
-	defer releasesFile.Close()
-	releases := struct {
-		LatestVersion string
-		DownloadUrl   string
-	}{}
-	json.NewDecoder(releasesFile).Decode(&releases)
+func syncCommand(args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	rolloutPath := flags.String("rollout", ROLLOUT_FILENAME, "Rollout file path, its versions are kept")
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
 
-	latestReleasePath := fmt.Sprintf("%s/releases/download/v%s/obsidian-%s.asar.gz", OBSIDIAN_GITHUB_PATH, releases.LatestVersion, releases.LatestVersion)
-	downloadFileIfChanged(fmt.Sprintf("https://github.com/%s", latestReleasePath), filepath.Join(downloadFolder, latestReleasePath))
-}
+	var err error
+	if scanRules, err = loadScanRules(config.Scan.Rules); err != nil {
+		return err
+	}
+	if err := checkRemovedPluginsAction(); err != nil {
+		return err
+	}
+	syncReport.StartedAt = time.Now().UTC()
+	desktopBefore := mirroredDesktopVersions(DOWNLOAD_FOLDER)
 
-func main() {
 	log.Println("[*] Pulling obsidian repo.")
-	var downloadFolder = filepath.Join(".", "files")
-	obsidianReleasesFolder := filepath.Join(downloadFolder, OBSIDIAN_GITHUB_PATH)
-	if err := os.MkdirAll(downloadFolder, os.ModeDir); err != nil {
-		log.Fatal(err)
-	}
-	if err := os.RemoveAll(obsidianReleasesFolder); err != nil {
-		log.Fatal(err)
+	obsidianReleasesFolder := filepath.Join(DOWNLOAD_FOLDER, OBSIDIAN_GITHUB_PATH)
+	if err := os.MkdirAll(DOWNLOAD_FOLDER, os.ModeDir); err != nil {
+		return err
 	}
 	if err := updateLocalGitRepo(obsidianReleasesFolder, OBSIDIAN_GITHUB_PATH); err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if err := provenance.recordGitRepo(obsidianReleasesFolder, OBSIDIAN_GITHUB_PATH); err != nil {
+		return err
 	}
 
-	log.Println("[*] Downloading latest desktop release, don't forget to patch it later!")
-	downloadLatestDesktopRelease(downloadFolder)
+	if config.Patch.ServerAddress == "" {
+		log.Println("[*] Downloading desktop releases, don't forget to patch them later!")
+	} else {
+		log.Println("[*] Downloading and patching desktop releases.")
+	}
+	if err := syncDesktopReleases(DOWNLOAD_FOLDER, *rolloutPath); err != nil {
+		log.Println(err)
+	}
 
 	log.Println("[*] Downloading themes stats")
-	downloadThemesStats(downloadFolder)
+	downloadThemesStats(DOWNLOAD_FOLDER)
+
+	if err := keepUpstreamCommunityLists(DOWNLOAD_FOLDER); err != nil {
+		return err
+	}
 
 	log.Println("[*] Getting repos list.")
-	pluginsAndThemesRepos := getPluginsAndThemesRepos(downloadFolder)
+	pluginsAndThemesRepos := getPluginsAndThemesRepos(DOWNLOAD_FOLDER)
 
 	fmt.Println("[*] Downloading repos.")
-	downloadPluginsAndThemes(downloadFolder, pluginsAndThemesRepos)
+	downloadPluginsAndThemes(DOWNLOAD_FOLDER, pluginsAndThemesRepos)
+
+	log.Println("[*] Handling removed plugins.")
+	if err := handleRemovedPlugins(DOWNLOAD_FOLDER); err != nil {
+		return err
+	}
+
+	if filtersCommunityLists() {
+		log.Println("[*] Writing filtered plugins and themes lists.")
+		if err := writeServedCommunityLists(DOWNLOAD_FOLDER); err != nil {
+			return err
+		}
+	}
+
+	log.Println("[*] Writing catalog.")
+	if err := writeCatalog(DOWNLOAD_FOLDER, CATALOG_FOLDER); err != nil {
+		log.Println(err)
+	}
+
+	log.Println("[*] Writing provenance.")
+	if err := provenance.flush(); err != nil {
+		return err
+	}
+
+	log.Println("[*] Writing search index and changelog.")
+	if index, err := writeSearchIndex(DOWNLOAD_FOLDER, SEARCH_INDEX_FILE); err != nil {
+		log.Println(err)
+	} else if changelog := buildChangelog(DOWNLOAD_FOLDER, index, desktopBefore); !changelog.isEmpty() {
+		if err := writeChangelog(DOWNLOAD_FOLDER, changelog); err != nil {
+			log.Println(err)
+		}
+	}
+
+	log.Println("[*] Writing checksums.")
+	if err := writeMirrorIndex(DOWNLOAD_FOLDER); err != nil {
+		return err
+	}
+
+	if config.Blobs.Enabled {
+		log.Println("[*] Storing blobs.")
+		stats, err := storeMirrorBlobs(DOWNLOAD_FOLDER)
+		if err != nil {
+			return err
+		}
+		log.Printf("[*] Stored %d files as %d blobs, saving %s", stats.Files, stats.Blobs, formatSize(stats.Saved))
+		syncReport.Dedup = &stats
+	}
+
+	syncReport.Commit = provenance.commit
+	reportPath, err := syncReport.write()
+	if err != nil {
+		return err
+	}
+	log.Printf("[*] Wrote sync report to %s", reportPath)
+	return nil
+}
+
+func parseCommandFlags(flags *flag.FlagSet, args []string) error {
+	configPath := flags.String("config", CONFIG_FILENAME, "Config file path")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var err error
+	config, err = loadConfig(*configPath)
+	return err
+}
+
+func main() {
+	commands := map[string]func(args []string) error{
+		"sync":         syncCommand,
+		"serve":        serveCommand,
+		"rollout":      rolloutCommand,
+		"doctor":       doctorCommand,
+		"verify":       verifyCommand,
+		"export":       exportCommand,
+		"sbom":         sbomCommand,
+		"licenses":     licensesCommand,
+		"capdiff":      capdiffCommand,
+		"review":       reviewCommand,
+		"offline":      offlineCommand,
+		"catalog":      catalogCommand,
+		"gc":           gcCommand,
+		"patch-check":  patchCheckCommand,
+		"patch-client": patchClientCommand,
+	}
+
+	command, args := "sync", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	run, ok := commands[command]
+	if !ok {
+		names := lo.Keys(commands)
+		sort.Strings(names)
+		log.Fatalf("[!] Unknown command: %s, available commands: %s", command, strings.Join(names, ", "))
+	}
+	if err := run(args); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// A small renderer for the markdown READMEs use, raw html is reduced to an
+// allowlist of tags and attributes so a README can't run scripts on the mirror
+var (
+	FENCE_REGEX           = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})\\s*([^`\\s]*)")
+	HEADING_REGEX         = regexp.MustCompile(`^ {0,3}(#{1,6})(?:\s+(.*?))?(?:\s+#+)?\s*$`)
+	SETEXT_REGEX          = regexp.MustCompile(`^ {0,3}(=+|-+)\s*$`)
+	HR_REGEX              = regexp.MustCompile(`^ {0,3}([-*_])(?:\s*[-*_]){2,}\s*$`)
+	BLOCKQUOTE_REGEX      = regexp.MustCompile(`^ {0,3}> ?`)
+	LIST_ITEM_REGEX       = regexp.MustCompile(`^( *)([-*+]|\d{1,9}[.)])(\s+|$)(.*)$`)
+	TASK_REGEX            = regexp.MustCompile(`^\[([ xX])\]\s+`)
+	TABLE_SEPARATOR_REGEX = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+	HTML_BLOCK_REGEX      = regexp.MustCompile(`^ {0,3}<(/?[a-zA-Z][a-zA-Z0-9]*[\s/>]|/?[a-zA-Z][a-zA-Z0-9]*$|!--)`)
+
+	CODE_SPAN_REGEX    = regexp.MustCompile("(`+)(.+?)(`+)")
+	ESCAPE_REGEX       = regexp.MustCompile("\\\\([!-/:-@\\[-`{-~])")
+	AUTOLINK_REGEX     = regexp.MustCompile(`<((?:https?|mailto):[^\s<>]+)>`)
+	IMAGE_REGEX        = regexp.MustCompile(`!\[([^\]]*)\]\(\s*<?(` + LINK_DESTINATION + `*)>?(?:\s+["']([^"']*)["'])?\s*\)`)
+	LINK_REGEX         = regexp.MustCompile(`\[([^\]]*)\]\(\s*<?(` + LINK_DESTINATION + `*)>?(?:\s+["']([^"']*)["'])?\s*\)`)
+	HTML_COMMENT_REGEX = regexp.MustCompile(`(?s)<!--.*?-->`)
+	HTML_TAG_REGEX     = regexp.MustCompile(`</?[a-zA-Z][a-zA-Z0-9]*(?:\s+[a-zA-Z_:][-a-zA-Z0-9_:.]*(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'=<>` + "`" + `]+))?)*\s*/?>`)
+	BARE_URL_REGEX     = regexp.MustCompile(`https?://[^\s<]+[^\s<.,:;"')\]!?*_~]`)
+	LINE_BREAK_REGEX   = regexp.MustCompile(`(?: {2,}|\\)\n`)
+	PLACEHOLDER_REGEX  = regexp.MustCompile("\x00(\\d+)\x00")
+	ENTITY_REGEX       = regexp.MustCompile(`^&(?:[a-zA-Z][a-zA-Z0-9]*|#\d+|#[xX][0-9a-fA-F]+);`)
+	TAG_PARTS_REGEX    = regexp.MustCompile(`^<(/?)([a-zA-Z][a-zA-Z0-9]*)(.*?)/?>$`)
+	ATTRIBUTE_REGEX    = regexp.MustCompile(`([a-zA-Z_:][-a-zA-Z0-9_:.]*)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+	URL_SCHEME_REGEX   = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*):`)
+	RENDERED_TAG_REGEX = regexp.MustCompile(`<(/?)([a-z][a-z0-9]*)[^>]*>`)
+
+	EMPHASIS_PATTERNS = []struct {
+		Regex       *regexp.Regexp
+		Replacement string
+	}{
+		{regexp.MustCompile(`\*\*([^*\s](?:.*?[^*\s])?)\*\*`), "<strong>$1</strong>"},
+		{regexp.MustCompile(`(^|[^\w])__([^_\s](?:.*?[^_\s])?)__([^\w]|$)`), "$1<strong>$2</strong>$3"},
+		{regexp.MustCompile(`\*([^*\s](?:[^*]*?[^*\s])?)\*`), "<em>$1</em>"},
+		{regexp.MustCompile(`(^|[^\w])_([^_\s](?:[^_]*?[^_\s])?)_([^\w]|$)`), "$1<em>$2</em>$3"},
+		{regexp.MustCompile(`~~([^~\s](?:.*?[^~\s])?)~~`), "<del>$1</del>"},
+	}
+
+	ALLOWED_TAGS = []string{
+		"a", "abbr", "b", "blockquote", "br", "center", "code", "dd", "del", "details", "div", "dl", "dt", "em",
+		"h1", "h2", "h3", "h4", "h5", "h6", "hr", "i", "img", "kbd", "li", "ol", "p", "pre", "s", "samp", "span",
+		"strong", "sub", "summary", "sup", "table", "tbody", "td", "tfoot", "th", "thead", "tr", "u", "ul",
+	}
+	ALLOWED_ATTRIBUTES = []string{"href", "src", "alt", "title", "width", "height", "align", "colspan", "rowspan", "open", "start"}
+	ALLOWED_SCHEMES    = []string{"http", "https", "mailto", "obsidian"}
+	VOID_TAGS          = []string{"br", "hr", "img", "input"}
+)
+
+// Link destinations can hold balanced parentheses, like wikipedia urls
+const LINK_DESTINATION = `(?:[^()\s<>]|\([^()\s<>]*\))`
+
+type markdownRenderer struct {
+	placeholders []string
+}
+
+func renderMarkdown(markdown string) string {
+	markdown = strings.ReplaceAll(markdown, "\x00", "")
+	markdown = strings.ReplaceAll(strings.ReplaceAll(markdown, "\r\n", "\n"), "\t", "    ")
+	return balanceHtml(renderMarkdownBlocks(strings.Split(markdown, "\n"), false))
+}
+
+func isBlankLine(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func endsWithBlankLine(lines []string) bool {
+	return len(lines) > 0 && isBlankLine(lines[len(lines)-1])
+}
+
+func lineIndent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// Lines that end a paragraph without a blank line in between
+func startsMarkdownBlock(line string) bool {
+	if FENCE_REGEX.MatchString(line) || HR_REGEX.MatchString(line) || BLOCKQUOTE_REGEX.MatchString(line) || HTML_BLOCK_REGEX.MatchString(line) {
+		return true
+	}
+	if HEADING_REGEX.MatchString(line) {
+		return true
+	}
+	match := LIST_ITEM_REGEX.FindStringSubmatch(line)
+	return match != nil && match[4] != "" && (!isOrderedMarker(match[2]) || strings.HasPrefix(match[2], "1"))
+}
+
+func isOrderedMarker(marker string) bool {
+	return marker[0] >= '0' && marker[0] <= '9'
+}
+
+func renderMarkdownBlocks(lines []string, tight bool) string {
+	var out strings.Builder
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlankLine(line):
+			i++
+
+		case FENCE_REGEX.MatchString(line):
+			match := FENCE_REGEX.FindStringSubmatch(line)
+			fence, indent := match[1], lineIndent(line)
+			var code []string
+			for i++; i < len(lines); i++ {
+				if trimmed := strings.TrimSpace(lines[i]); strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+					i++
+					break
+				}
+				code = append(code, lines[i][lo.Min([]int{indent, lineIndent(lines[i])}):])
+			}
+			class := ""
+			if match[2] != "" {
+				class = fmt.Sprintf(` class="language-%s"`, html.EscapeString(match[2]))
+			}
+			fmt.Fprintf(&out, "<pre><code%s>%s</code></pre>\n", class, html.EscapeString(strings.Join(code, "\n")))
+
+		case HEADING_REGEX.MatchString(line):
+			match := HEADING_REGEX.FindStringSubmatch(line)
+			fmt.Fprintf(&out, "<h%d>%s</h%d>\n", len(match[1]), renderMarkdownInline(match[2]), len(match[1]))
+			i++
+
+		case HR_REGEX.MatchString(line):
+			out.WriteString("<hr>\n")
+			i++
+
+		case BLOCKQUOTE_REGEX.MatchString(line):
+			var quoted []string
+			for ; i < len(lines) && !isBlankLine(lines[i]); i++ {
+				if !BLOCKQUOTE_REGEX.MatchString(lines[i]) && startsMarkdownBlock(lines[i]) {
+					break
+				}
+				quoted = append(quoted, BLOCKQUOTE_REGEX.ReplaceAllString(lines[i], ""))
+			}
+			fmt.Fprintf(&out, "<blockquote>\n%s</blockquote>\n", renderMarkdownBlocks(quoted, false))
+
+		case LIST_ITEM_REGEX.MatchString(line) && LIST_ITEM_REGEX.FindStringSubmatch(line)[4] != "":
+			var list string
+			list, i = renderMarkdownList(lines, i)
+			out.WriteString(list)
+
+		case HTML_BLOCK_REGEX.MatchString(line):
+			var block []string
+			for ; i < len(lines) && !isBlankLine(lines[i]); i++ {
+				block = append(block, lines[i])
+			}
+			out.WriteString(sanitizeHtml(strings.Join(block, "\n")) + "\n")
+
+		case i+1 < len(lines) && strings.Contains(line, "|") && TABLE_SEPARATOR_REGEX.MatchString(lines[i+1]):
+			var table string
+			table, i = renderMarkdownTable(lines, i)
+			out.WriteString(table)
+
+		default:
+			var paragraph []string
+			level := 0
+			for ; i < len(lines) && !isBlankLine(lines[i]); i++ {
+				if match := SETEXT_REGEX.FindStringSubmatch(lines[i]); match != nil && len(paragraph) > 0 {
+					level = lo.Ternary(match[1][0] == '=', 1, 2)
+					i++
+					break
+				}
+				if len(paragraph) > 0 && startsMarkdownBlock(lines[i]) {
+					break
+				}
+				paragraph = append(paragraph, lines[i])
+			}
+			text := renderMarkdownInline(strings.Join(lo.Map(paragraph, func(line string, _ int) string { return strings.TrimLeft(line, " ") }), "\n"))
+			switch {
+			case level > 0:
+				fmt.Fprintf(&out, "<h%d>%s</h%d>\n", level, text, level)
+			case tight:
+				out.WriteString(text + "\n")
+			default:
+				fmt.Fprintf(&out, "<p>%s</p>\n", text)
+			}
+		}
+	}
+	return out.String()
+}
+
+// Items continue while lines are indented past the marker, a list is loose
+// when its items are separated by blank lines
+func renderMarkdownList(lines []string, start int) (string, int) {
+	first := LIST_ITEM_REGEX.FindStringSubmatch(lines[start])
+	ordered, indent := isOrderedMarker(first[2]), len(first[1])
+
+	var items [][]string
+	loose := false
+	contentIndent := 0
+	i := start
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if match := LIST_ITEM_REGEX.FindStringSubmatch(line); match != nil && len(match[1]) <= indent+1 && isOrderedMarker(match[2]) == ordered {
+			if len(items) > 0 && endsWithBlankLine(items[len(items)-1]) {
+				loose = true
+			}
+			contentIndent = len(match[1]) + len(match[2]) + lo.Ternary(len(match[3]) > 4 || match[3] == "", 1, len(match[3]))
+			items = append(items, []string{match[4]})
+			continue
+		}
+
+		item := &items[len(items)-1]
+		switch {
+		case isBlankLine(line):
+			next, ok := lo.Find(lines[i+1:], func(line string) bool { return !isBlankLine(line) })
+			if !ok || lineIndent(next) < contentIndent && !LIST_ITEM_REGEX.MatchString(next) || lineIndent(next) < indent {
+				return renderMarkdownListItems(items, ordered, first[2], loose), i
+			}
+			*item = append(*item, "")
+		case lineIndent(line) >= contentIndent:
+			*item = append(*item, line[contentIndent:])
+		case !endsWithBlankLine(*item) && !startsMarkdownBlock(line):
+			*item = append(*item, strings.TrimLeft(line, " "))
+		default:
+			return renderMarkdownListItems(items, ordered, first[2], loose), i
+		}
+	}
+	return renderMarkdownListItems(items, ordered, first[2], loose), i
+}
+
+func renderMarkdownListItems(items [][]string, ordered bool, marker string, loose bool) string {
+	var out strings.Builder
+	if !ordered {
+		out.WriteString("<ul>\n")
+	} else if number, _ := strconv.Atoi(strings.TrimRight(marker, ".)")); number != 1 {
+		fmt.Fprintf(&out, "<ol start=\"%d\">\n", number)
+	} else {
+		out.WriteString("<ol>\n")
+	}
+
+	for _, item := range items {
+		checkbox := ""
+		if match := TASK_REGEX.FindStringSubmatch(item[0]); match != nil {
+			checkbox = lo.Ternary(match[1] == " ", `<input type="checkbox" disabled> `, `<input type="checkbox" disabled checked> `)
+			item[0] = item[0][len(match[0]):]
+		}
+		for len(item) > 0 && isBlankLine(item[len(item)-1]) {
+			item = item[:len(item)-1]
+		}
+		fmt.Fprintf(&out, "<li>%s%s</li>\n", checkbox, strings.TrimSuffix(renderMarkdownBlocks(item, !loose), "\n"))
+	}
+	out.WriteString(lo.Ternary(ordered, "</ol>\n", "</ul>\n"))
+	return out.String()
+}
+
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimSuffix(strings.TrimPrefix(line, "|"), "|")
+	var cells []string
+	cell := ""
+	for j := 0; j < len(line); j++ {
+		switch {
+		case line[j] == '\\' && j+1 < len(line) && line[j+1] == '|':
+			cell += "|"
+			j++
+		case line[j] == '|':
+			cells = append(cells, strings.TrimSpace(cell))
+			cell = ""
+		default:
+			cell += string(line[j])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell))
+}
+
+func renderMarkdownTable(lines []string, start int) (string, int) {
+	header := splitTableRow(lines[start])
+	aligns := lo.Map(splitTableRow(lines[start+1]), func(separator string, _ int) string {
+		switch {
+		case strings.HasPrefix(separator, ":") && strings.HasSuffix(separator, ":"):
+			return ` align="center"`
+		case strings.HasSuffix(separator, ":"):
+			return ` align="right"`
+		case strings.HasPrefix(separator, ":"):
+			return ` align="left"`
+		}
+		return ""
+	})
+
+	row := func(cells []string, tag string) string {
+		var out strings.Builder
+		out.WriteString("<tr>")
+		for j := range header {
+			cell := ""
+			if j < len(cells) {
+				cell = cells[j]
+			}
+			align := ""
+			if j < len(aligns) {
+				align = aligns[j]
+			}
+			fmt.Fprintf(&out, "<%s%s>%s</%s>", tag, align, renderMarkdownInline(cell), tag)
+		}
+		out.WriteString("</tr>\n")
+		return out.String()
+	}
+
+	var out strings.Builder
+	out.WriteString("<table>\n<thead>\n" + row(header, "th") + "</thead>\n<tbody>\n")
+	i := start + 2
+	for ; i < len(lines) && !isBlankLine(lines[i]) && strings.Contains(lines[i], "|"); i++ {
+		out.WriteString(row(splitTableRow(lines[i]), "td"))
+	}
+	out.WriteString("</tbody>\n</table>\n")
+	return out.String(), i
+}
+
+func (r *markdownRenderer) placeholder(rendered string) string {
+	r.placeholders = append(r.placeholders, rendered)
+	return fmt.Sprintf("\x00%d\x00", len(r.placeholders)-1)
+}
+
+// Code, links and html are swapped for placeholders so the text around
+// them can be escaped and emphasised without touching them
+func renderMarkdownInline(text string) string {
+	r := &markdownRenderer{}
+	text = CODE_SPAN_REGEX.ReplaceAllStringFunc(text, func(match string) string {
+		groups := CODE_SPAN_REGEX.FindStringSubmatch(match)
+		if groups[1] != groups[3] {
+			return match
+		}
+		return r.placeholder("<code>" + html.EscapeString(strings.TrimSpace(groups[2])) + "</code>")
+	})
+	text = ESCAPE_REGEX.ReplaceAllStringFunc(text, func(match string) string {
+		return r.placeholder(html.EscapeString(match[1:]))
+	})
+	text = AUTOLINK_REGEX.ReplaceAllStringFunc(text, func(match string) string {
+		link := AUTOLINK_REGEX.FindStringSubmatch(match)[1]
+		return r.placeholder(fmt.Sprintf(`<a href="%s">%s</a>`, safeUrl(link), html.EscapeString(link)))
+	})
+	text = IMAGE_REGEX.ReplaceAllStringFunc(text, func(match string) string {
+		groups := IMAGE_REGEX.FindStringSubmatch(match)
+		return r.placeholder(fmt.Sprintf(`<img src="%s" alt="%s"%s>`, safeUrl(groups[2]), html.EscapeString(groups[1]), titleAttribute(groups[3])))
+	})
+	text = LINK_REGEX.ReplaceAllStringFunc(text, func(match string) string {
+		groups := LINK_REGEX.FindStringSubmatch(match)
+		return r.placeholder(fmt.Sprintf(`<a href="%s"%s>%s</a>`, safeUrl(groups[2]), titleAttribute(groups[3]), r.renderText(groups[1])))
+	})
+	text = HTML_COMMENT_REGEX.ReplaceAllString(text, "")
+	text = HTML_TAG_REGEX.ReplaceAllStringFunc(text, func(tag string) string {
+		return r.placeholder(sanitizeTag(tag))
+	})
+	text = BARE_URL_REGEX.ReplaceAllStringFunc(text, func(link string) string {
+		return r.placeholder(fmt.Sprintf(`<a href="%s">%s</a>`, safeUrl(link), html.EscapeString(link)))
+	})
+	text = r.renderText(text)
+
+	for PLACEHOLDER_REGEX.MatchString(text) {
+		text = PLACEHOLDER_REGEX.ReplaceAllStringFunc(text, func(match string) string {
+			index, _ := strconv.Atoi(PLACEHOLDER_REGEX.FindStringSubmatch(match)[1])
+			return r.placeholders[index]
+		})
+	}
+	return text
+}
+
+func (r *markdownRenderer) renderText(text string) string {
+	text = escapeHtmlText(text)
+	for _, pattern := range EMPHASIS_PATTERNS {
+		text = pattern.Regex.ReplaceAllString(text, pattern.Replacement)
+	}
+	return LINE_BREAK_REGEX.ReplaceAllString(text, "<br>\n")
+}
+
+func titleAttribute(title string) string {
+	if title == "" {
+		return ""
+	}
+	return fmt.Sprintf(` title="%s"`, html.EscapeString(title))
+}
+
+// Escapes markup but keeps entities, which markdown and html both allow in text
+func escapeHtmlText(text string) string {
+	var out strings.Builder
+	for j := 0; j < len(text); j++ {
+		switch text[j] {
+		case '<':
+			out.WriteString("&lt;")
+		case '>':
+			out.WriteString("&gt;")
+		case '"':
+			out.WriteString("&quot;")
+		case '&':
+			out.WriteString(lo.Ternary(ENTITY_REGEX.MatchString(text[j:]), "&", "&amp;"))
+		default:
+			out.WriteByte(text[j])
+		}
+	}
+	return out.String()
+}
+
+func safeUrl(link string) string {
+	link = strings.TrimSpace(html.UnescapeString(link))
+	normalized := strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, link)
+	if match := URL_SCHEME_REGEX.FindStringSubmatch(normalized); match != nil {
+		scheme := strings.ToLower(match[1])
+		isDataImage := scheme == "data" && strings.HasPrefix(strings.ToLower(normalized), "data:image/") && !strings.HasPrefix(strings.ToLower(normalized), "data:image/svg")
+		if !lo.Contains(ALLOWED_SCHEMES, scheme) && !isDataImage {
+			return "#"
+		}
+	}
+	return html.EscapeString(link)
+}
+
+func sanitizeTag(tag string) string {
+	match := TAG_PARTS_REGEX.FindStringSubmatch(tag)
+	if match == nil {
+		return html.EscapeString(tag)
+	}
+	name := strings.ToLower(match[2])
+	if !lo.Contains(ALLOWED_TAGS, name) {
+		return html.EscapeString(tag)
+	}
+	if match[1] == "/" {
+		return "</" + name + ">"
+	}
+
+	var out strings.Builder
+	out.WriteString("<" + name)
+	for _, attribute := range ATTRIBUTE_REGEX.FindAllStringSubmatch(match[3], -1) {
+		attributeName := strings.ToLower(attribute[1])
+		if !lo.Contains(ALLOWED_ATTRIBUTES, attributeName) {
+			continue
+		}
+		value := attribute[2] + attribute[3] + attribute[4]
+		if attributeName == "href" || attributeName == "src" {
+			value = safeUrl(value)
+		} else {
+			value = html.EscapeString(html.UnescapeString(value))
+		}
+		fmt.Fprintf(&out, ` %s="%s"`, attributeName, value)
+	}
+	out.WriteString(">")
+	return out.String()
+}
+
+// Raw html blocks keep their allowed tags, everything else is shown as text
+func sanitizeHtml(block string) string {
+	block = HTML_COMMENT_REGEX.ReplaceAllString(block, "")
+	var out strings.Builder
+	last := 0
+	for _, match := range HTML_TAG_REGEX.FindAllStringIndex(block, -1) {
+		out.WriteString(escapeHtmlText(block[last:match[0]]))
+		out.WriteString(sanitizeTag(block[match[0]:match[1]]))
+		last = match[1]
+	}
+	out.WriteString(escapeHtmlText(block[last:]))
+	return out.String()
+}
+
+// Raw html can leave tags open or close tags that were never opened, the
+// rendered output only holds the tags the renderer wrote so it's enough to
+// drop stray closing tags and close whatever is still open at the end
+func balanceHtml(rendered string) string {
+	var out strings.Builder
+	var open []string
+	last := 0
+	for _, match := range RENDERED_TAG_REGEX.FindAllStringSubmatchIndex(rendered, -1) {
+		out.WriteString(rendered[last:match[0]])
+		last = match[1]
+		name := rendered[match[4]:match[5]]
+		switch {
+		case lo.Contains(VOID_TAGS, name):
+			out.WriteString(rendered[match[0]:match[1]])
+		case match[3] == match[2]:
+			open = append(open, name)
+			out.WriteString(rendered[match[0]:match[1]])
+		default:
+			index := lo.LastIndexOf(open, name)
+			if index < 0 {
+				continue
+			}
+			for len(open) > index {
+				out.WriteString("</" + open[len(open)-1] + ">")
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	out.WriteString(rendered[last:])
+	for len(open) > 0 {
+		out.WriteString("</" + open[len(open)-1] + ">")
+		open = open[:len(open)-1]
+	}
+	return out.String()
+}
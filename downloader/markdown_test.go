@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdownSanitizes(t *testing.T) {
+	tests := []struct {
+		name      string
+		markdown  string
+		contains  []string
+		forbidden []string
+	}{
+		{"javascript link", "[x](javascript:alert(1))", []string{`<a href="#">x</a>`}, []string{"javascript"}},
+		{"javascript html link", `<a href="javascript:alert(1)">x</a>`, []string{`<a href="#">x</a>`}, []string{"javascript"}},
+		{"uppercase scheme", `<a href="JaVaScRiPt:alert(1)">x</a>`, []string{`href="#"`}, []string{"alert"}},
+		{"decimal entity scheme", `<a href="&#106;avascript:alert(1)">x</a>`, []string{`href="#"`}, []string{"alert"}},
+		{"hex entity scheme", "[x](&#x6A;avascript:alert(1))", []string{`href="#"`}, []string{"alert"}},
+		{"named entity in scheme", `<a href="javascript&colon;alert(1)">x</a>`, []string{`href="#"`}, []string{"alert"}},
+		{"tab in scheme", `<a href="java&#x09;script:alert(1)">x</a>`, []string{`href="#"`}, []string{"alert"}},
+		{"vbscript image", `<img src="vbscript:msgbox(1)">`, []string{`src="#"`}, []string{"vbscript"}},
+		{"svg data image", "![x](data:image/svg+xml;base64,PHN2Zz4=)", []string{`src="#"`}, []string{"data:"}},
+		{"png data image", "![x](data:image/png;base64,iVBORw0KGgo=)", []string{`src="data:image/png;base64,iVBORw0KGgo="`}, nil},
+		{"event attribute", `<img src="a.png" onerror="alert(1)">`, []string{`<img src="a.png">`}, []string{"onerror", "alert"}},
+		{"unquoted event attribute", `<div onclick=alert(1) align=center>x</div>`, []string{`<div align="center">`}, []string{"onclick", "alert"}},
+		{"inline event attribute", `text <span onmouseover="alert(1)">x</span>`, []string{`<span>x</span>`}, []string{"onmouseover"}},
+		{"style attribute", `<p style="background:url(javascript:alert(1))">x</p>`, []string{"<p>x</p>"}, []string{"style", "javascript"}},
+		{"script tag", "<script>alert(1)</script>", []string{"&lt;script&gt;"}, []string{"<script"}},
+		{"inline script tag", "text <script>alert(1)</script>", []string{"&lt;script&gt;"}, []string{"<script"}},
+		{"iframe", `<iframe src="https://example.com"></iframe>`, []string{"&lt;iframe"}, []string{"<iframe"}},
+		{"svg onload", `<svg onload="alert(1)">`, []string{"&lt;svg"}, []string{"<svg"}},
+		{"attribute breakout", `<a href="x" title='a" onclick="alert(1)'>x</a>`, []string{`title="a&#34; onclick=&#34;alert(1)"`}, []string{` onclick="`}},
+		{"code span", "`<script>`", []string{"<code>&lt;script&gt;</code>"}, []string{"<script"}},
+		{"parentheses in link", "[link](https://example.com/a_(b))", []string{`<a href="https://example.com/a_(b)">link</a>`}, []string{"</a>)"}},
+		{"parentheses in image", "![x](https://example.com/a_(b).png)", []string{`src="https://example.com/a_(b).png"`}, []string{".png)"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rendered := renderMarkdown(test.markdown)
+			for _, expected := range test.contains {
+				if !strings.Contains(rendered, expected) {
+					t.Errorf("expected %q in %q", expected, rendered)
+				}
+			}
+			for _, forbidden := range test.forbidden {
+				if strings.Contains(rendered, forbidden) {
+					t.Errorf("unexpected %q in %q", forbidden, rendered)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderMarkdownBalancesTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		expected string
+	}{
+		{"unclosed details", "<details><summary>More\n\n- a", "<details><summary>More\n<ul>\n<li>a</li>\n</ul>\n</summary></details>"},
+		{"unclosed table", "<table><tr><td>x", "<table><tr><td>x\n</td></tr></table>"},
+		{"details across blocks", "<details><summary>More</summary>\n\n- a\n\n</details>\n\ntext", "<details><summary>More</summary>\n<ul>\n<li>a</li>\n</ul>\n</details>\n<p>text</p>\n"},
+		{"stray closing tag", "a </div> b", "<p>a  b</p>\n"},
+		{"misnested tags", "<b><i>x</b></i>", "<b><i>x</i></b>\n"},
+		{"inline tag left open", "a <b>bold\n\nnext", "<p>a <b>bold</b></p>\n<p>next</p>\n"},
+		{"void tags", "a<br>b <img src=\"x.png\">", "<p>a<br>b <img src=\"x.png\"></p>\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if rendered := renderMarkdown(test.markdown); rendered != test.expected {
+				t.Errorf("expected %q, rendered %q", test.expected, rendered)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/samber/lo"
+)
+
+const (
+	OFFLINE_CAPABLE     = "offline"
+	OFFLINE_DEGRADED    = "degraded"
+	OFFLINE_ONLINE_ONLY = "online-only"
+)
+
+var (
+	OFFLINE_CLASSES       = []string{OFFLINE_CAPABLE, OFFLINE_DEGRADED, OFFLINE_ONLINE_ONLY}
+	NETWORK_CALL_PATTERNS = []struct {
+		Name  string
+		Regex *regexp.Regexp
+	}{
+		{"fetch", regexp.MustCompile(`\bfetch\s*\(`)},
+		{"requestUrl", regexp.MustCompile(`\brequestUrl\s*\(`)},
+		{"XMLHttpRequest", regexp.MustCompile(`\bnew\s+XMLHttpRequest\b`)},
+		{"WebSocket", regexp.MustCompile(`\bnew\s+WebSocket\s*\(`)},
+		{"EventSource", regexp.MustCompile(`\bnew\s+EventSource\s*\(`)},
+	}
+	ONLINE_README_REGEX = regexp.MustCompile(`(?i)\b(api[ -]?keys?|access[ -]?tokens?|internet connection|requires? (?:an )?(?:online|internet)|sign[ -]?in|log[ -]?in|subscription|openai|chatgpt)\b`)
+
+	offlineClassifications     = map[string]OfflineClassification{}
+	offlineClassificationsLock sync.Mutex
+)
+
+type OfflineClassification struct {
+	Class   string   `json:"class"`
+	Hosts   []string `json:"hosts,omitempty"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+type OfflineEntry struct {
+	Id   string `json:"id"`
+	Repo string `json:"repo"`
+	OfflineClassification
+}
+
+func isIgnoredHost(host string) bool {
+	return lo.SomeBy(config.Offline.IgnoredHosts, func(ignored string) bool {
+		return host == ignored || strings.HasSuffix(host, "."+ignored)
+	})
+}
+
+func externalHosts(script string) []string {
+	hosts := lo.FilterMap(ENDPOINT_REGEX.FindAllString(script, -1), func(endpoint string, _ int) (string, bool) {
+		endpointUrl, err := url.Parse(endpoint)
+		if err != nil {
+			return "", false
+		}
+		host := strings.ToLower(endpointUrl.Hostname())
+		return host, !isIgnoredHost(host)
+	})
+	hosts = lo.Uniq(hosts)
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Images a README shows aren't services the plugin talks to
+func readmeServiceHosts(readme string) []string {
+	readme = MARKDOWN_IMAGE_REGEX.ReplaceAllString(readme, "")
+	return externalHosts(HTML_IMAGE_REGEX.ReplaceAllString(readme, ""))
+}
+
+func classifyOffline(script string, readme string) OfflineClassification {
+	hosts := externalHosts(script)
+	var calls []string
+	for _, pattern := range NETWORK_CALL_PATTERNS {
+		if pattern.Regex.MatchString(script) {
+			calls = append(calls, pattern.Name)
+		}
+	}
+
+	classification := OfflineClassification{Class: OFFLINE_CAPABLE, Hosts: hosts}
+	if len(calls) == 0 {
+		classification.Reasons = append(classification.Reasons, "no network calls")
+		return classification
+	}
+	classification.Reasons = append(classification.Reasons, "network calls: "+strings.Join(calls, ", "))
+	// A service documented only in the README still needs the network
+	readmeHosts := lo.Without(readmeServiceHosts(readme), hosts...)
+	if len(hosts) == 0 && len(readmeHosts) == 0 {
+		classification.Reasons = append(classification.Reasons, "no hard-coded external hosts")
+		return classification
+	}
+
+	classification.Class = OFFLINE_DEGRADED
+	if len(hosts) > 0 {
+		classification.Reasons = append(classification.Reasons, fmt.Sprintf("%d hard-coded external hosts", len(hosts)))
+	}
+	if len(readmeHosts) > 0 {
+		classification.Hosts = append(classification.Hosts, readmeHosts...)
+		sort.Strings(classification.Hosts)
+		classification.Reasons = append(classification.Reasons, fmt.Sprintf("%d external hosts in the README", len(readmeHosts)))
+	}
+	if markers := lo.Uniq(lo.Map(ONLINE_README_REGEX.FindAllString(readme, -1), func(marker string, _ int) string { return strings.ToLower(marker) })); len(markers) > 0 {
+		classification.Class = OFFLINE_ONLINE_ONLY
+		classification.Reasons = append(classification.Reasons, "README mentions: "+strings.Join(markers, ", "))
+	}
+	return classification
+}
+
+func classifyPluginOffline(repoFolder string, repo string) (OfflineClassification, error) {
+	var classification OfflineClassification
+	manifest, err := readManifest(repoFolder)
+	if err != nil {
+		return classification, err
+	}
+	key := repo + "@" + manifest.Version
+	offlineClassificationsLock.Lock()
+	classification, ok := offlineClassifications[key]
+	offlineClassificationsLock.Unlock()
+	if ok {
+		return classification, nil
+	}
+
+	script, err := os.ReadFile(pluginScriptPath(pluginReleaseFolder(repoFolder, manifest.Version)))
+	if err != nil {
+		return classification, err
+	}
+	// The served README points its images at the mirror, the upstream one is read
+	readmePath := filepath.Join(repoFolder, README_FILE)
+	readme, err := os.ReadFile(readmePath + ORIGINAL_FILE_SUFFIX)
+	if err != nil {
+		readme, _ = os.ReadFile(readmePath)
+	}
+
+	classification = classifyOffline(string(script), string(readme))
+	if class, ok := config.Offline.Overrides[repo]; ok {
+		classification.Class = class
+		classification.Reasons = append(classification.Reasons, "configured override")
+	}
+
+	offlineClassificationsLock.Lock()
+	offlineClassifications[key] = classification
+	offlineClassificationsLock.Unlock()
+	return classification, nil
+}
+
+func collectOfflineClassifications(downloadFolder string) ([]OfflineEntry, error) {
+	plugins, err := readUpstreamCommunityPlugins(downloadFolder)
+	if err != nil {
+		return nil, fmt.Errorf("[!] Error reading plugins list, run sync first: %s", err)
+	}
+
+	var entries []OfflineEntry
+	for _, plugin := range plugins {
+		classification, err := classifyPluginOffline(filepath.Join(downloadFolder, plugin.Repo), plugin.Repo)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, OfflineEntry{Id: plugin.Id, Repo: plugin.Repo, OfflineClassification: classification})
+	}
+	return entries, nil
+}
+
+func writeOfflineReport(out io.Writer, entries []OfflineEntry) {
+	groups := lo.GroupBy(entries, func(entry OfflineEntry) string { return entry.Class })
+
+	fmt.Fprintln(out, "# Offline compatibility")
+	fmt.Fprintln(out)
+	for _, class := range OFFLINE_CLASSES {
+		fmt.Fprintf(out, "- %s: %d\n", class, len(groups[class]))
+	}
+	for _, class := range OFFLINE_CLASSES {
+		fmt.Fprintf(out, "\n## %s\n\n", class)
+		for _, entry := range groups[class] {
+			fmt.Fprintf(out, "- %s (%s)", entry.Id, entry.Repo)
+			if len(entry.Hosts) > 0 {
+				fmt.Fprintf(out, ": %s", strings.Join(entry.Hosts, ", "))
+			}
+			fmt.Fprintln(out)
+		}
+	}
+}
+
+func offlineCommand(args []string) error {
+	flags := flag.NewFlagSet("offline", flag.ExitOnError)
+	format := flags.String("format", "markdown", "Report format, markdown or json")
+	class := flags.String("class", "", "Only list plugins of this class, offline, degraded or online-only")
+	output := flags.String("o", "-", "Output file, - for stdout")
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+
+	entries, err := collectOfflineClassifications(DOWNLOAD_FOLDER)
+	if err != nil {
+		return err
+	}
+	if *class != "" {
+		entries = lo.Filter(entries, func(entry OfflineEntry, _ int) bool { return entry.Class == *class })
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+	writeOfflineReport(out, entries)
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClassifyOffline(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		script string
+		readme string
+		class  string
+		hosts  []string
+	}{
+		{name: "no network calls", script: `const url = "https://api.example.com"`, readme: "Set your api key", class: OFFLINE_CAPABLE, hosts: []string{"api.example.com"}},
+		{name: "user configured urls", script: `fetch(this.settings.url)`, readme: "Point it at your server.", class: OFFLINE_CAPABLE},
+		{name: "hard-coded host", script: `fetch("https://api.example.com/v1")`, class: OFFLINE_DEGRADED, hosts: []string{"api.example.com"}},
+		{name: "hard-coded host and api key", script: `fetch("https://api.example.com/v1")`, readme: "Paste your API key in the settings", class: OFFLINE_ONLINE_ONLY, hosts: []string{"api.example.com"}},
+		{name: "host only in the readme", script: `requestUrl({url: base + "/v1"})`, readme: "Uses the [service](https://api.example.com) to translate notes.", class: OFFLINE_DEGRADED, hosts: []string{"api.example.com"}},
+		{name: "host only in the readme with an account", script: `requestUrl({url: base + "/v1"})`, readme: "Sign in at https://app.example.com first.", class: OFFLINE_ONLINE_ONLY, hosts: []string{"app.example.com"}},
+		{name: "readme hosts are merged", script: `fetch("https://api.example.com/v1")`, readme: "See https://docs.example.org", class: OFFLINE_DEGRADED, hosts: []string{"api.example.com", "docs.example.org"}},
+		{name: "readme images and ignored hosts", script: `fetch(this.settings.url)`, readme: "![demo](https://img.example.com/demo.gif) <img src=\"https://cdn.example.com/a.png\"> from https://github.com/owner/repo", class: OFFLINE_CAPABLE},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			classification := classifyOffline(test.script, test.readme)
+			if classification.Class != test.class {
+				t.Errorf("got class %s, want %s (%v)", classification.Class, test.class, classification.Reasons)
+			}
+			if !reflect.DeepEqual(classification.Hosts, test.hosts) && (len(classification.Hosts) > 0 || len(test.hosts) > 0) {
+				t.Errorf("got hosts %v, want %v", classification.Hosts, test.hosts)
+			}
+		})
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"downloader/asar"
+	"github.com/samber/lo"
+)
+
+const (
+	ORIGINAL_FILE_SUFFIX = ".orig"
+	SERVER_PLACEHOLDER   = "{{server}}"
+	ANY_FILE             = "*"
+	SNIPPET_CONTEXT      = 40
+)
+
+var (
+	ORIGINAL_SERVER_ADDRESSES = []string{
+		"https://raw.githubusercontent.com",
+		"https://github.com",
+		"https://releases.obsidian.md",
+	}
+
+	//go:embed patch-rules.json
+	DEFAULT_PATCH_RULES []byte
+)
+
+type MatchCount struct {
+	Min *int `json:"min,omitempty"`
+	Max *int `json:"max,omitempty"`
+}
+
+type PatchMatcher struct {
+	Name    string                `json:"name"`
+	Files   []string              `json:"files"`
+	Literal string                `json:"literal,omitempty"`
+	Regex   string                `json:"regex,omitempty"`
+	Expect  map[string]MatchCount `json:"expect,omitempty"`
+	regex   *regexp.Regexp
+}
+
+type PatchRule struct {
+	PatchMatcher
+	Replace string `json:"replace"`
+}
+
+type PatchRules struct {
+	Rules      []*PatchRule    `json:"rules"`
+	Assertions []*PatchMatcher `json:"assertions"`
+}
+
+type PatchReportEntry struct {
+	Kind     string
+	Name     string
+	File     string
+	Count    int
+	Expected MatchCount
+	Ok       bool
+	Snippets []string
+}
+
+type PatchReport struct {
+	Entries []PatchReportEntry
+}
+
+type PatchError struct {
+	Target string
+	Report PatchReport
+}
+
+func (err *PatchError) Error() string {
+	return fmt.Sprintf("[!] Patch rules did not match as expected: %s\n%s", err.Target, err.Report.String())
+}
+
+func (count MatchCount) String() string {
+	switch {
+	case count.Min != nil && count.Max != nil && *count.Min == *count.Max:
+		return fmt.Sprintf("=%d", *count.Min)
+	case count.Min != nil && count.Max != nil:
+		return fmt.Sprintf("%d..%d", *count.Min, *count.Max)
+	case count.Min != nil:
+		return fmt.Sprintf(">=%d", *count.Min)
+	case count.Max != nil:
+		return fmt.Sprintf("<=%d", *count.Max)
+	}
+	return "any"
+}
+
+func (count MatchCount) allows(matches int) bool {
+	return (count.Min == nil || matches >= *count.Min) && (count.Max == nil || matches <= *count.Max)
+}
+
+func loadPatchRules(rulesPath string) (PatchRules, error) {
+	var rules PatchRules
+	data := DEFAULT_PATCH_RULES
+	if rulesPath != "" {
+		var err error
+		if data, err = os.ReadFile(rulesPath); err != nil {
+			return rules, err
+		}
+	}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return rules, fmt.Errorf("[!] Error parsing patch rules: %s, %s", rulesPath, err)
+	}
+
+	matchers := append(lo.Map(rules.Rules, func(rule *PatchRule, _ int) *PatchMatcher { return &rule.PatchMatcher }), rules.Assertions...)
+	for _, matcher := range matchers {
+		if (matcher.Literal == "") == (matcher.Regex == "") {
+			return rules, fmt.Errorf("[!] Patch rule %s needs exactly one of literal or regex", matcher.Name)
+		}
+		if matcher.Regex != "" {
+			regex, err := regexp.Compile(matcher.Regex)
+			if err != nil {
+				return rules, fmt.Errorf("[!] Patch rule %s has an invalid regex: %s", matcher.Name, err)
+			}
+			matcher.regex = regex
+		}
+	}
+	return rules, nil
+}
+
+func (rules PatchRules) targetFiles() []string {
+	files := lo.FlatMap(rules.Rules, func(rule *PatchRule, _ int) []string { return rule.Files })
+	files = append(files, lo.FlatMap(rules.Assertions, func(assertion *PatchMatcher, _ int) []string { return assertion.Files })...)
+	files = lo.Uniq(files)
+	sort.Strings(files)
+	return files
+}
+
+func (matcher *PatchMatcher) find(content string) [][]int {
+	if matcher.regex != nil {
+		return matcher.regex.FindAllStringSubmatchIndex(content, -1)
+	}
+
+	var matches [][]int
+	for start := 0; ; {
+		index := strings.Index(content[start:], matcher.Literal)
+		if index < 0 {
+			return matches
+		}
+		matches = append(matches, []int{start + index, start + index + len(matcher.Literal)})
+		start += index + len(matcher.Literal)
+	}
+}
+
+// A matcher without expect has to match at least once across the files it
+// covers, so a renamed prefix fails instead of leaving the bundle half patched
+func (matcher *PatchMatcher) totalEntry(kind string, entries []PatchReportEntry) (PatchReportEntry, bool) {
+	if len(matcher.Expect) > 0 || len(entries) == 0 {
+		return PatchReportEntry{}, false
+	}
+	one := 1
+	count := lo.SumBy(entries, func(entry PatchReportEntry) int { return entry.Count })
+	return PatchReportEntry{Kind: kind, Name: matcher.Name, File: ANY_FILE, Count: count, Expected: MatchCount{Min: &one}, Ok: count >= 1}, true
+}
+
+func (matcher *PatchMatcher) expected(file string) MatchCount {
+	if count, ok := matcher.Expect[file]; ok {
+		return count
+	}
+	return matcher.Expect[ANY_FILE]
+}
+
+func snippet(content string, start int, end int) string {
+	from, to := lo.Max([]int{0, start - SNIPPET_CONTEXT}), lo.Min([]int{len(content), end + SNIPPET_CONTEXT})
+	return strings.ReplaceAll(content[from:to], "\n", "\\n")
+}
+
+func (rule *PatchRule) apply(content string, serverAddress string) (string, []string) {
+	replace := strings.ReplaceAll(rule.Replace, SERVER_PLACEHOLDER, serverAddress)
+
+	var patched strings.Builder
+	var snippets []string
+	last := 0
+	for _, match := range rule.find(content) {
+		replacement := replace
+		if rule.regex != nil {
+			replacement = string(rule.regex.ExpandString(nil, replace, content, match))
+		}
+		patched.WriteString(content[last:match[0]])
+		patched.WriteString(replacement)
+		last = match[1]
+
+		after := content[lo.Max([]int{0, match[0] - SNIPPET_CONTEXT}):match[0]] + replacement + content[match[1]:lo.Min([]int{len(content), match[1] + SNIPPET_CONTEXT})]
+		snippets = append(snippets, "- "+snippet(content, match[0], match[1]), "+ "+strings.ReplaceAll(after, "\n", "\\n"))
+	}
+	patched.WriteString(content[last:])
+	return patched.String(), snippets
+}
+
+func (rules PatchRules) apply(files map[string]string, serverAddress string) (map[string]string, PatchReport) {
+	var report PatchReport
+	patched := make(map[string]string, len(files))
+	for file, content := range files {
+		patched[file] = content
+	}
+
+	for _, rule := range rules.Rules {
+		var entries []PatchReportEntry
+		for _, file := range rule.Files {
+			content, ok := patched[file]
+			if !ok {
+				continue
+			}
+			expected := rule.expected(file)
+			count := len(rule.find(content))
+			var snippets []string
+			patched[file], snippets = rule.apply(content, serverAddress)
+			entries = append(entries, PatchReportEntry{
+				Kind:     "rule",
+				Name:     rule.Name,
+				File:     file,
+				Count:    count,
+				Expected: expected,
+				Ok:       expected.allows(count),
+				Snippets: snippets,
+			})
+		}
+		if total, ok := rule.totalEntry("rule", entries); ok {
+			entries = append(entries, total)
+		}
+		report.Entries = append(report.Entries, entries...)
+	}
+
+	for _, assertion := range rules.Assertions {
+		var entries []PatchReportEntry
+		for _, file := range assertion.Files {
+			content, ok := patched[file]
+			if !ok {
+				continue
+			}
+			matches := assertion.find(content)
+			expected := assertion.expected(file)
+			entry := PatchReportEntry{
+				Kind:     "assertion",
+				Name:     assertion.Name,
+				File:     file,
+				Count:    len(matches),
+				Expected: expected,
+				Ok:       expected.allows(len(matches)),
+			}
+			if !entry.Ok {
+				entry.Snippets = lo.Map(matches, func(match []int, _ int) string { return "! " + snippet(content, match[0], match[1]) })
+			}
+			entries = append(entries, entry)
+		}
+		if total, ok := assertion.totalEntry("assertion", entries); ok {
+			entries = append(entries, total)
+		}
+		report.Entries = append(report.Entries, entries...)
+	}
+	return patched, report
+}
+
+func (report PatchReport) Failed() bool {
+	return lo.SomeBy(report.Entries, func(entry PatchReportEntry) bool { return !entry.Ok })
+}
+
+func (report PatchReport) String() string {
+	var out strings.Builder
+	for _, entry := range report.Entries {
+		fmt.Fprintf(&out, "[%s] %s %s in %s: %d matches, expected %s\n", lo.Ternary(entry.Ok, "ok", "FAIL"), entry.Kind, entry.Name, entry.File, entry.Count, entry.Expected)
+		if entry.Ok && entry.Kind == "rule" {
+			continue
+		}
+		for _, line := range entry.Snippets {
+			fmt.Fprintf(&out, "    %s\n", line)
+		}
+	}
+	return out.String()
+}
+
+func patchServerAddresses(text string, serverAddress string) string {
+	for _, server := range ORIGINAL_SERVER_ADDRESSES {
+		text = strings.ReplaceAll(text, server, serverAddress)
+	}
+	return text
+}
+
+func patchAsarArchive(archive *asar.Archive, target string, rules PatchRules, serverAddress string) (PatchReport, error) {
+	files := map[string]string{}
+	for _, file := range rules.targetFiles() {
+		content, err := archive.ReadFile(file)
+		if err == asar.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return PatchReport{}, err
+		}
+		files[file] = string(content)
+	}
+	if len(files) == 0 {
+		return PatchReport{}, fmt.Errorf("[!] Cannot find %s in %s", strings.Join(rules.targetFiles(), "/"), target)
+	}
+
+	patched, report := rules.apply(files, serverAddress)
+	if report.Failed() {
+		return report, &PatchError{Target: target, Report: report}
+	}
+	for file, content := range patched {
+		if err := archive.WriteFile(file, []byte(content)); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func openAsar(archivePath string) (*asar.Archive, error) {
+	if !strings.HasSuffix(strings.TrimSuffix(archivePath, ORIGINAL_FILE_SUFFIX), ".gz") {
+		return asar.Open(archivePath)
+	}
+
+	archiveFile, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer archiveFile.Close()
+
+	reader, err := gzip.NewReader(archiveFile)
+	if err != nil {
+		return nil, fmt.Errorf("[!] Error decompressing: %s, %s", archivePath, err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("[!] Error decompressing: %s, %s", archivePath, err)
+	}
+
+	archive, err := asar.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("[!] Error reading asar: %s, %s", archivePath, err)
+	}
+	return archive, nil
+}
+
+func patchGzippedAsar(originalPath string, patchedPath string, rules PatchRules, serverAddress string) error {
+	archive, err := openAsar(originalPath)
+	if err != nil {
+		return err
+	}
+	if _, err := patchAsarArchive(archive, originalPath, rules, serverAddress); err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	writer := gzip.NewWriter(&out)
+	if err := archive.Encode(writer); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return replaceFile(patchedPath, out.Bytes(), 0644)
+}
+
+func patchCheckCommand(args []string) error {
+	flags := flag.NewFlagSet("patch-check", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: patch-check [flags] <asar or asar.gz>...")
+		flags.PrintDefaults()
+	}
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+
+	rules, err := loadPatchRules(config.Patch.Rules)
+	if err != nil {
+		return err
+	}
+	serverAddress := lo.Ternary(config.Patch.ServerAddress != "", config.Patch.ServerAddress, "http://obsidian-server/files")
+
+	failed := false
+	for _, archivePath := range flags.Args() {
+		archive, err := openAsar(archivePath)
+		if err != nil {
+			return err
+		}
+		report, err := patchAsarArchive(archive, archivePath, rules, serverAddress)
+		if _, ok := err.(*PatchError); err != nil && !ok {
+			return err
+		}
+		fmt.Printf("%s\n%s\n", archivePath, report.String())
+		failed = failed || report.Failed()
+	}
+	if failed {
+		return fmt.Errorf("[!] Patch rules did not match as expected")
+	}
+	return nil
+}
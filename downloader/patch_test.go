@@ -0,0 +1,192 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const TEST_SERVER_ADDRESS = "https://mirror.example.com/files"
+
+func loadTestPatchRules(t *testing.T, rules string) PatchRules {
+	t.Helper()
+	if rules == "" {
+		patchRules, err := loadPatchRules("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return patchRules
+	}
+	rulesPath := filepath.Join(t.TempDir(), "patch-rules.json")
+	if err := os.WriteFile(rulesPath, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	patchRules, err := loadPatchRules(rulesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return patchRules
+}
+
+func failedEntries(report PatchReport) []string {
+	failed := []string{}
+	for _, entry := range report.Entries {
+		if !entry.Ok {
+			failed = append(failed, entry.Kind+" "+entry.Name+" "+entry.File)
+		}
+	}
+	sort.Strings(failed)
+	return failed
+}
+
+func TestPatchRules(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		rules   string
+		files   map[string]string
+		failed  []string
+		patched map[string]string
+	}{
+		{
+			name:   "default rules patch a bundle",
+			files:  map[string]string{"main.js": TEST_CLIENT_MAIN_JS, "app.js": `get("https://github.com/obsidianmd/obsidian-releases")`},
+			failed: []string{},
+			patched: map[string]string{
+				"main.js": `fetch("` + TEST_SERVER_ADDRESS + `/obsidianmd/obsidian-releases/HEAD/desktop-releases.json");
+download("` + TEST_SERVER_ADDRESS + `/obsidianmd/obsidian-releases/releases/download/v1.6.7/obsidian-1.6.7.asar.gz");
+open("` + TEST_SERVER_ADDRESS + `/desktop");
+let verifiedHash = true ||  hash === expected;
+let verifiedSignature = true ||  verify(signature);`,
+				"app.js": `get("` + TEST_SERVER_ADDRESS + `/obsidianmd/obsidian-releases")`,
+			},
+		},
+		{
+			name:  "default rules fail when a verification is renamed",
+			files: map[string]string{"main.js": strings.ReplaceAll(TEST_CLIENT_MAIN_JS, "let verifiedSignature", "const signatureOk")},
+			failed: []string{
+				"assertion signature-verification-skipped *",
+				"rule skip-signature-verification *",
+			},
+		},
+		{
+			name:   "a rule without expect can match in any of its files",
+			rules:  `{"rules": [{"name": "server", "files": ["a.js", "b.js"], "literal": "https://github.com", "replace": "{{server}}"}]}`,
+			files:  map[string]string{"a.js": "none", "b.js": "https://github.com/x"},
+			failed: []string{},
+			patched: map[string]string{
+				"a.js": "none",
+				"b.js": TEST_SERVER_ADDRESS + "/x",
+			},
+		},
+		{
+			name:   "a rule without expect has to match once",
+			rules:  `{"rules": [{"name": "server", "files": ["a.js", "b.js"], "literal": "https://github.com", "replace": "{{server}}"}]}`,
+			files:  map[string]string{"a.js": "none", "b.js": "none"},
+			failed: []string{"rule server *"},
+		},
+		{
+			name:   "a rule whose files are all missing is skipped",
+			rules:  `{"rules": [{"name": "server", "files": ["a.js"], "literal": "https://github.com", "replace": "{{server}}"}]}`,
+			files:  map[string]string{"other.js": "https://github.com"},
+			failed: []string{},
+		},
+		{
+			name:   "exact expect count matches",
+			rules:  `{"rules": [{"name": "twice", "files": ["a.js"], "literal": "x", "replace": "y", "expect": {"a.js": {"min": 2, "max": 2}}}]}`,
+			files:  map[string]string{"a.js": "x-x"},
+			failed: []string{},
+			patched: map[string]string{
+				"a.js": "y-y",
+			},
+		},
+		{
+			name:   "exact expect count fails on fewer matches",
+			rules:  `{"rules": [{"name": "twice", "files": ["a.js"], "literal": "x", "replace": "y", "expect": {"a.js": {"min": 2, "max": 2}}}]}`,
+			files:  map[string]string{"a.js": "x"},
+			failed: []string{"rule twice a.js"},
+		},
+		{
+			name:   "exact expect count fails on more matches",
+			rules:  `{"rules": [{"name": "twice", "files": ["a.js"], "literal": "x", "replace": "y", "expect": {"a.js": {"min": 2, "max": 2}}}]}`,
+			files:  map[string]string{"a.js": "x-x-x"},
+			failed: []string{"rule twice a.js"},
+		},
+		{
+			name:   "expect for * covers files without their own count",
+			rules:  `{"rules": [{"name": "once", "files": ["a.js", "b.js"], "literal": "x", "replace": "y", "expect": {"*": {"min": 1}, "a.js": {"max": 0}}}]}`,
+			files:  map[string]string{"a.js": "x", "b.js": "none"},
+			failed: []string{"rule once a.js", "rule once b.js"},
+		},
+		{
+			name:   "expect without a count for the file allows any count",
+			rules:  `{"rules": [{"name": "b-only", "files": ["a.js", "b.js"], "literal": "x", "replace": "y", "expect": {"b.js": {"min": 1}}}]}`,
+			files:  map[string]string{"a.js": "none", "b.js": "x"},
+			failed: []string{},
+		},
+		{
+			name:   "regex replacements expand groups and the server",
+			rules:  `{"rules": [{"name": "url", "files": ["a.js"], "regex": "https://(github|gitlab)\\.com", "replace": "{{server}}/$1"}]}`,
+			files:  map[string]string{"a.js": "https://github.com/a https://gitlab.com/b"},
+			failed: []string{},
+			patched: map[string]string{
+				"a.js": TEST_SERVER_ADDRESS + "/github/a " + TEST_SERVER_ADDRESS + "/gitlab/b",
+			},
+		},
+		{
+			name: "assertions check the patched files",
+			rules: `{"rules": [{"name": "raw", "files": ["a.js"], "literal": "https://raw.githubusercontent.com", "replace": "{{server}}"}],
+				"assertions": [{"name": "no-github", "files": ["a.js"], "literal": "https://github.com", "expect": {"*": {"max": 0}}},
+				{"name": "no-raw", "files": ["a.js"], "literal": "https://raw.githubusercontent.com", "expect": {"*": {"max": 0}}}]}`,
+			files:  map[string]string{"a.js": "https://raw.githubusercontent.com https://github.com"},
+			failed: []string{"assertion no-github a.js"},
+		},
+		{
+			name:   "an assertion without expect has to match once",
+			rules:  `{"assertions": [{"name": "patched", "files": ["a.js", "b.js"], "literal": "patched"}]}`,
+			files:  map[string]string{"a.js": "none", "b.js": "none"},
+			failed: []string{"assertion patched *"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rules := loadTestPatchRules(t, test.rules)
+			patched, report := rules.apply(test.files, TEST_SERVER_ADDRESS)
+			if failed := failedEntries(report); !reflect.DeepEqual(failed, test.failed) {
+				t.Errorf("failed entries: got %v, want %v\n%s", failed, test.failed, report)
+			}
+			if report.Failed() != (len(test.failed) > 0) {
+				t.Errorf("Failed() is %v with failed entries %v", report.Failed(), test.failed)
+			}
+			for file, content := range test.patched {
+				if patched[file] != content {
+					t.Errorf("%s: got %q, want %q", file, patched[file], content)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadPatchRulesErrors(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		rules string
+		err   string
+	}{
+		{name: "literal and regex", rules: `{"rules": [{"name": "both", "files": ["a.js"], "literal": "a", "regex": "a"}]}`, err: "exactly one of literal or regex"},
+		{name: "no match", rules: `{"assertions": [{"name": "none", "files": ["a.js"]}]}`, err: "exactly one of literal or regex"},
+		{name: "invalid regex", rules: `{"rules": [{"name": "bad", "files": ["a.js"], "regex": "("}]}`, err: "invalid regex"},
+		{name: "invalid json", rules: `{"rules": [`, err: "Error parsing patch rules"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rulesPath := filepath.Join(t.TempDir(), "patch-rules.json")
+			if err := os.WriteFile(rulesPath, []byte(test.rules), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadPatchRules(rulesPath); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got %v, want an error containing %q", err, test.err)
+			}
+		})
+	}
+}
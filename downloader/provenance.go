@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+)
+
+const (
+	PROVENANCE_FILENAME = ".provenance.json"
+	MIRROR_INDEX_FILE   = "SHA256SUMS"
+)
+
+var PROVENANCE_HEADERS = []string{"Content-Type", "Content-Length", "ETag", "Last-Modified"}
+
+type Provenance struct {
+	Url          string            `json:"url,omitempty"`
+	DerivedFrom  string            `json:"derivedFrom,omitempty"`
+	FetchedAt    time.Time         `json:"fetchedAt"`
+	Sha256       string            `json:"sha256"`
+	Size         int64             `json:"size"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Commit       string            `json:"commit,omitempty"`
+	RewrittenFor string            `json:"rewrittenFor,omitempty"`
+}
+
+type provenanceRecorder struct {
+	sync.Mutex
+	commit  string
+	records map[string]map[string]Provenance
+}
+
+var provenance = &provenanceRecorder{records: map[string]map[string]Provenance{}}
+
+func provenanceFolder(downloadFolder string, filePath string) (string, string) {
+	relativePath, err := filepath.Rel(downloadFolder, filePath)
+	if err != nil || strings.HasPrefix(relativePath, "..") {
+		return "", ""
+	}
+	parts := strings.Split(filepath.ToSlash(relativePath), "/")
+	if len(parts) < 3 {
+		return downloadFolder, filepath.ToSlash(relativePath)
+	}
+	return filepath.Join(downloadFolder, parts[0], parts[1]), strings.Join(parts[2:], "/")
+}
+
+func (recorder *provenanceRecorder) add(filePath string, record Provenance) {
+	folder, name := provenanceFolder(DOWNLOAD_FOLDER, filePath)
+	if folder == "" {
+		return
+	}
+
+	recorder.Lock()
+	defer recorder.Unlock()
+	record.Commit = recorder.commit
+	if recorder.records[folder] == nil {
+		recorder.records[folder] = map[string]Provenance{}
+	}
+	recorder.records[folder][name] = record
+}
+
+func (recorder *provenanceRecorder) recordDownload(fileUrl string, filePath string, resp *http.Response, body []byte) {
+	sum := sha256.Sum256(body)
+	recorder.recordResponse(fileUrl, filePath, resp, hex.EncodeToString(sum[:]), int64(len(body)))
+}
+
+// Streamed downloads are recorded with the sha computed while writing them
+func (recorder *provenanceRecorder) recordResponse(fileUrl string, filePath string, resp *http.Response, sha string, size int64) {
+	headers := map[string]string{}
+	for _, header := range PROVENANCE_HEADERS {
+		if value := resp.Header.Get(header); value != "" {
+			headers[header] = value
+		}
+	}
+	recorder.add(filePath, Provenance{
+		Url:       fileUrl,
+		FetchedAt: time.Now().UTC(),
+		Sha256:    sha,
+		Size:      size,
+		Headers:   headers,
+	})
+}
+
+func (recorder *provenanceRecorder) recordDerived(filePath string, sourcePath string) {
+	recorder.recordRewritten(filePath, sourcePath, "")
+}
+
+// Files rewritten for the mirror keep the server address and options they were rewritten for
+func (recorder *provenanceRecorder) recordRewritten(filePath string, sourcePath string, rewrittenFor string) {
+	sha, err := fileSha256(filePath)
+	if err != nil {
+		return
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return
+	}
+	_, sourceName := provenanceFolder(DOWNLOAD_FOLDER, sourcePath)
+	recorder.add(filePath, Provenance{
+		DerivedFrom:  sourceName,
+		FetchedAt:    time.Now().UTC(),
+		Sha256:       sha,
+		Size:         info.Size(),
+		RewrittenFor: rewrittenFor,
+	})
+}
+
+// Records of this sync come first, then the flushed ones
+func (recorder *provenanceRecorder) rewrittenFor(filePath string) string {
+	folder, name := provenanceFolder(DOWNLOAD_FOLDER, filePath)
+	if folder == "" {
+		return ""
+	}
+
+	recorder.Lock()
+	record, ok := recorder.records[folder][name]
+	recorder.Unlock()
+	if !ok {
+		record = readProvenance(folder)[name]
+	}
+	return record.RewrittenFor
+}
+
+func (recorder *provenanceRecorder) recordFile(filePath string, fileUrl string) {
+	sha, err := fileSha256(filePath)
+	if err != nil {
+		return
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return
+	}
+	recorder.add(filePath, Provenance{
+		Url:       fileUrl,
+		FetchedAt: time.Now().UTC(),
+		Sha256:    sha,
+		Size:      info.Size(),
+	})
+}
+
+func (recorder *provenanceRecorder) recordGitRepo(repoFolder string, repoUrlPath string) error {
+	repo, err := git.PlainOpen(repoFolder)
+	if err != nil {
+		return err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	recorder.commit = head.Hash().String()
+
+	files, _ := filepath.Glob(filepath.Join(repoFolder, "*.json"))
+	for _, file := range files {
+		if strings.HasSuffix(file, UPSTREAM_FILE_SUFFIX) {
+			continue
+		}
+		recorder.recordGitFile(file, repoUrlPath, filepath.Base(file))
+	}
+	return nil
+}
+
+// Copies kept of a checked out file come from the same commit
+func (recorder *provenanceRecorder) recordGitFile(filePath string, repoUrlPath string, file string) {
+	recorder.recordFile(filePath, fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s", repoUrlPath, recorder.commit, file))
+}
+
+// The next flush drops the records of the files deleted from the folder of filePath
+func (recorder *provenanceRecorder) prune(filePath string) {
+	folder, _ := provenanceFolder(DOWNLOAD_FOLDER, filePath)
+	if folder == "" {
+		return
+	}
+
+	recorder.Lock()
+	defer recorder.Unlock()
+	if recorder.records[folder] == nil {
+		recorder.records[folder] = map[string]Provenance{}
+	}
+}
+
+func readProvenance(folder string) map[string]Provenance {
+	records := map[string]Provenance{}
+	data, err := os.ReadFile(filepath.Join(folder, PROVENANCE_FILENAME))
+	if err == nil {
+		json.Unmarshal(data, &records)
+	}
+	return records
+}
+
+func (recorder *provenanceRecorder) flush() error {
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	for folder, records := range recorder.records {
+		merged := readProvenance(folder)
+		for name, record := range records {
+			merged[name] = record
+		}
+		for name := range merged {
+			if _, err := os.Stat(filepath.Join(folder, filepath.FromSlash(name))); err != nil {
+				delete(merged, name)
+			}
+		}
+
+		data, err := json.MarshalIndent(merged, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(folder, PROVENANCE_FILENAME), data, 0644); err != nil {
+			return err
+		}
+	}
+	recorder.records = map[string]map[string]Provenance{}
+	return nil
+}
+
+func isIndexedFile(relativePath string) bool {
+	name := filepath.Base(relativePath)
+	return name != PROVENANCE_FILENAME && relativePath != MIRROR_INDEX_FILE
+}
+
+func mirrorFiles(downloadFolder string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(downloadFolder, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		relativePath, _ := filepath.Rel(downloadFolder, filePath)
+		if info.Mode().IsRegular() && isIndexedFile(filepath.ToSlash(relativePath)) {
+			files = append(files, filepath.ToSlash(relativePath))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+func writeMirrorIndex(downloadFolder string) error {
+	files, err := mirrorFiles(downloadFolder)
+	if err != nil {
+		return err
+	}
+
+	index, err := os.Create(filepath.Join(downloadFolder, MIRROR_INDEX_FILE))
+	if err != nil {
+		return err
+	}
+	defer index.Close()
+
+	writer := bufio.NewWriter(index)
+	for _, file := range files {
+		sha, err := fileSha256(filepath.Join(downloadFolder, filepath.FromSlash(file)))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(writer, "%s  %s\n", sha, file)
+	}
+	return writer.Flush()
+}
+
+func readMirrorIndex(downloadFolder string) (map[string]string, []string, error) {
+	index, err := os.Open(filepath.Join(downloadFolder, MIRROR_INDEX_FILE))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer index.Close()
+
+	sums := map[string]string{}
+	var files []string
+	scanner := bufio.NewScanner(index)
+	for scanner.Scan() {
+		sha, file, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			continue
+		}
+		sums[file] = sha
+		files = append(files, file)
+	}
+	return sums, files, scanner.Err()
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+const (
+	README_FILE          = "README.md"
+	README_HTML_FILE     = "README.html"
+	README_ASSETS_FOLDER = "readme-assets"
+)
+
+var (
+	MARKDOWN_IMAGE_REGEX  = regexp.MustCompile(`(!\[[^\]]*\]\(\s*<?)(` + LINK_DESTINATION + `+)`)
+	HTML_IMAGE_REGEX      = regexp.MustCompile(`(?i)(<img\s[^>]*?src\s*=\s*["']?)([^"'\s>]+)`)
+	GITHUB_FILE_URL_REGEX = regexp.MustCompile(`^https?://(?:github\.com/([^/]+/[^/]+)/(?:blob|raw)|raw\.githubusercontent\.com/([^/]+/[^/]+))/([^/]+)/([^?#]+)`)
+	// Only images are stored at their raw path, so a README can't overwrite mirrored files
+	README_IMAGE_EXTENSIONS = []string{".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".avif", ".bmp", ".ico"}
+	README_HTML_TEMPLATE    = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="Content-Security-Policy" content="default-src 'none'; img-src 'self' data:; style-src 'unsafe-inline'">
+<title>%s</title>
+<style>
+body { max-width: 860px; margin: 2em auto; padding: 0 1em; font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; line-height: 1.5; color: #222; }
+img { max-width: 100%%; }
+pre { background: #f5f5f5; padding: 1em; overflow: auto; }
+code { background: #f5f5f5; padding: 0 .2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ddd; padding: .3em .6em; }
+blockquote { margin: 0; padding-left: 1em; border-left: 4px solid #ddd; color: #555; }
+</style>
+</head>
+<body>
+%s
+</body>
+</html>
+`
+)
+
+func mirrorsReadmeImages() bool {
+	return config.Readme.MirrorImages
+}
+
+// Recorded with README.md and README.html, a README downloaded as is or
+// rendered with other options is written again
+func readmeRewrittenFor(file string) string {
+	return fmt.Sprintf("%s mirrorImages=%t", file, config.Readme.MirrorImages)
+}
+
+// Images in the repo are stored at their raw path so relative references
+// resolve as is, other images go to readme-assets and are referenced relatively
+func readmeImageLocation(repo string, repoFolder string, reference string) (*url.URL, string, bool) {
+	imageUrl, localPath, ok := readmeImageSource(repo, repoFolder, reference)
+	if localPath != "" && !lo.Contains(README_IMAGE_EXTENSIONS, strings.ToLower(filepath.Ext(localPath))) {
+		localPath = ""
+	}
+	return imageUrl, localPath, ok
+}
+
+func readmeImageSource(repo string, repoFolder string, reference string) (*url.URL, string, bool) {
+	if strings.HasPrefix(reference, "#") || strings.HasPrefix(reference, "data:") {
+		return nil, "", false
+	}
+	referenceUrl, err := url.Parse(html.UnescapeString(reference))
+	if err != nil {
+		return nil, "", false
+	}
+
+	if !referenceUrl.IsAbs() && referenceUrl.Host == "" {
+		filePath := strings.TrimPrefix(path.Clean("/"+referenceUrl.Path), "/")
+		fileUrl, _ := url.Parse(fmt.Sprintf("https://raw.githubusercontent.com/%s/HEAD/%s", repo, filePath))
+		return fileUrl, filepath.Join(repoFolder, filepath.FromSlash(filePath)), true
+	}
+	if referenceUrl.Scheme != "http" && referenceUrl.Scheme != "https" {
+		return nil, "", false
+	}
+
+	if match := GITHUB_FILE_URL_REGEX.FindStringSubmatch(referenceUrl.String()); match != nil {
+		fileRepo, ref, filePath := lo.Ternary(match[1] != "", match[1], match[2]), match[3], strings.TrimPrefix(path.Clean("/"+match[4]), "/")
+		referenceUrl, _ = url.Parse(fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s", fileRepo, ref, filePath))
+		if strings.EqualFold(fileRepo, repo) && lo.Contains([]string{"HEAD", "master", "main"}, ref) {
+			return referenceUrl, filepath.Join(repoFolder, filepath.FromSlash(filePath)), true
+		}
+	}
+	return referenceUrl, "", true
+}
+
+func mirrorReadmeImage(repo string, repoFolder string, reference string) (string, error) {
+	imageUrl, localPath, ok := readmeImageLocation(repo, repoFolder, reference)
+	if !ok {
+		return reference, nil
+	}
+	resp, body, err := fetchAsset(imageUrl)
+	if err != nil {
+		return reference, err
+	}
+	if localPath == "" {
+		localPath = mirroredAssetPath(filepath.Join(repoFolder, README_ASSETS_FOLDER), imageUrl, resp.Header.Get("Content-Type"))
+	}
+	if err := writeAsset(localPath, imageUrl, resp, body); err != nil {
+		return reference, err
+	}
+
+	relativePath, _ := filepath.Rel(repoFolder, localPath)
+	return filepath.ToSlash(relativePath), nil
+}
+
+func rewriteReadmeImages(repo string, repoFolder string, readme string) string {
+	mirrored := map[string]string{}
+	rewrite := func(regex *regexp.Regexp) func(match string) string {
+		return func(match string) string {
+			groups := regex.FindStringSubmatch(match)
+			reference := groups[2]
+			if _, ok := mirrored[reference]; !ok {
+				rewritten, err := mirrorReadmeImage(repo, repoFolder, reference)
+				if err != nil {
+					log.Printf("%v\n\n", err)
+				}
+				mirrored[reference] = rewritten
+			}
+			return groups[1] + mirrored[reference]
+		}
+	}
+	readme = MARKDOWN_IMAGE_REGEX.ReplaceAllStringFunc(readme, rewrite(MARKDOWN_IMAGE_REGEX))
+	return HTML_IMAGE_REGEX.ReplaceAllStringFunc(readme, rewrite(HTML_IMAGE_REGEX))
+}
+
+func writeReadmeHtml(repo string, repoFolder string, readme string) error {
+	htmlPath := filepath.Join(repoFolder, README_HTML_FILE)
+	page := fmt.Sprintf(README_HTML_TEMPLATE, html.EscapeString(repo), renderMarkdown(readme))
+	if err := replaceFile(htmlPath, []byte(page), 0644); err != nil {
+		return err
+	}
+	provenance.recordRewritten(htmlPath, filepath.Join(repoFolder, README_FILE), readmeRewrittenFor(README_HTML_FILE))
+	return nil
+}
+
+func mirrorReadme(repo Repo, repoFolder string) error {
+	readmePath := filepath.Join(repoFolder, README_FILE)
+	if mirrorsReadmeImages() {
+		origInfo, err := os.Stat(readmePath + ORIGINAL_FILE_SUFFIX)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !isRewritten(readmePath, origInfo, readmeRewrittenFor(README_FILE)) {
+			readme, err := os.ReadFile(readmePath + ORIGINAL_FILE_SUFFIX)
+			if err != nil {
+				return err
+			}
+			rewritten := rewriteReadmeImages(repo.Repo, repoFolder, string(readme))
+			if err := replaceFile(readmePath, []byte(rewritten), 0644); err != nil {
+				return err
+			}
+			provenance.recordRewritten(readmePath, readmePath+ORIGINAL_FILE_SUFFIX, readmeRewrittenFor(README_FILE))
+		}
+	}
+
+	htmlPath := filepath.Join(repoFolder, README_HTML_FILE)
+	if !config.Readme.RenderHtml {
+		if err := os.Remove(htmlPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	readmeInfo, err := os.Stat(readmePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if isRewritten(htmlPath, readmeInfo, readmeRewrittenFor(README_HTML_FILE)) {
+		return nil
+	}
+	readme, err := os.ReadFile(readmePath)
+	if err != nil {
+		return err
+	}
+	return writeReadmeHtml(repo.Repo, repoFolder, string(readme))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const RELEASE_NOTES_FILE = "release-notes.md"
+
+func pluginReleaseNotesUrl(pluginUrlPath string, version string) string {
+	return fmt.Sprintf("https://github.com/%s/releases/tag/%s", pluginUrlPath, version)
+}
+
+func readReleaseNotes(releaseFolder string) string {
+	notes, _ := os.ReadFile(filepath.Join(releaseFolder, RELEASE_NOTES_FILE))
+	return strings.TrimSpace(string(notes))
+}
+
+// Notes are fetched once per version, an empty body is kept as an empty file
+func downloadReleaseNotes(pluginUrlPath string, version string, releaseFolder string) error {
+	notesPath := filepath.Join(releaseFolder, RELEASE_NOTES_FILE)
+	if _, err := os.Stat(notesPath); err == nil {
+		return nil
+	}
+	release, err := getGithubRelease(pluginUrlPath, version)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(releaseFolder, 0755); err != nil {
+		return err
+	}
+	if err := replaceFile(notesPath, []byte(strings.ReplaceAll(release.Body, "\r\n", "\n")), 0644); err != nil {
+		return err
+	}
+	provenance.recordFile(notesPath, pluginReleaseNotesUrl(pluginUrlPath, version))
+	return nil
+}
+
+// The notes of the new version go with it through quarantine, versions
+// mirrored before notes were kept get theirs on the way
+func mirrorReleaseNotes(repo Repo, repoFolder string, version string, releaseFolder string) {
+	if githubRateLimited.Load() {
+		return
+	}
+	if err := downloadReleaseNotes(repo.Repo, version, releaseFolder); err != nil {
+		log.Printf("[!] Error downloading release notes: %s %s, %s\n\n", repo.Repo, version, err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(repoFolder, "releases", "download"))
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == version || githubRateLimited.Load() {
+			continue
+		}
+		if err := downloadReleaseNotes(repo.Repo, entry.Name(), pluginReleaseFolder(repoFolder, entry.Name())); err != nil {
+			log.Printf("[!] Error downloading release notes: %s %s, %s\n\n", repo.Repo, entry.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+const (
+	DESKTOP_RELEASE_INFO_FILE      = "desktop-release.json"
+	DESKTOP_RELEASES_UPSTREAM_FILE = "desktop-releases.upstream.json"
+)
+
+type DesktopRelease struct {
+	LatestVersion string `json:"latestVersion"`
+	DownloadUrl   string `json:"downloadUrl"`
+	Hash          string `json:"hash,omitempty"`
+	Signature     string `json:"signature,omitempty"`
+}
+
+type DesktopReleases struct {
+	MinimumVersion string `json:"minimumVersion,omitempty"`
+	DesktopRelease
+	Beta *DesktopRelease `json:"beta,omitempty"`
+}
+
+type mirroredDesktopRelease struct {
+	DesktopRelease
+	IsBeta     bool   `json:"isBeta,omitempty"`
+	PatchedFor string `json:"patchedFor,omitempty"`
+}
+
+func compareVersions(a string, b string) int {
+	aParts := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bParts := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var aPart, bPart int
+		if i < len(aParts) {
+			aPart, _ = strconv.Atoi(aParts[i])
+		}
+		if i < len(bParts) {
+			bPart, _ = strconv.Atoi(bParts[i])
+		}
+		if aPart != bPart {
+			if aPart < bPart {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func desktopReleasePath(version string) string {
+	return fmt.Sprintf("%s/releases/download/v%s/obsidian-%s.asar.gz", OBSIDIAN_GITHUB_PATH, version, version)
+}
+
+func desktopReleaseFolder(downloadFolder string, version string) string {
+	return filepath.Join(downloadFolder, OBSIDIAN_GITHUB_PATH, "releases", "download", "v"+version)
+}
+
+func readDesktopReleases(downloadFolder string, releasesFilename string) (DesktopReleases, error) {
+	var releases DesktopReleases
+	releasesFile, err := os.Open(filepath.Join(downloadFolder, OBSIDIAN_GITHUB_PATH, releasesFilename))
+	if err != nil {
+		return releases, err
+	}
+	defer releasesFile.Close()
+	err = json.NewDecoder(releasesFile).Decode(&releases)
+	return releases, err
+}
+
+func readMirroredDesktopRelease(downloadFolder string, version string) (mirroredDesktopRelease, error) {
+	var release mirroredDesktopRelease
+	infoFile, err := os.Open(filepath.Join(desktopReleaseFolder(downloadFolder, version), DESKTOP_RELEASE_INFO_FILE))
+	if err != nil {
+		return release, err
+	}
+	defer infoFile.Close()
+	err = json.NewDecoder(infoFile).Decode(&release)
+	return release, err
+}
+
+func listMirroredDesktopReleases(downloadFolder string) []mirroredDesktopRelease {
+	var releases []mirroredDesktopRelease
+	entries, _ := os.ReadDir(filepath.Join(downloadFolder, OBSIDIAN_GITHUB_PATH, "releases", "download"))
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), "v") {
+			continue
+		}
+		release, err := readMirroredDesktopRelease(downloadFolder, strings.TrimPrefix(entry.Name(), "v"))
+		if err != nil {
+			continue
+		}
+		releases = append(releases, release)
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return compareVersions(releases[i].LatestVersion, releases[j].LatestVersion) > 0
+	})
+	return releases
+}
+
+func downloadDesktopRelease(downloadFolder string, release DesktopRelease, isBeta bool) error {
+	releasePath := desktopReleasePath(release.LatestVersion)
+	releaseFile := filepath.Join(downloadFolder, releasePath)
+	releaseUrl := release.DownloadUrl
+	if releaseUrl == "" {
+		releaseUrl = fmt.Sprintf("https://github.com/%s", releasePath)
+	}
+
+	mirrored := mirroredDesktopRelease{DesktopRelease: release, IsBeta: isBeta}
+	if config.Patch.ServerAddress == "" {
+		downloadFileIfChanged(releaseUrl, releaseFile)
+	} else {
+		previous, _ := readMirroredDesktopRelease(downloadFolder, release.LatestVersion)
+		changed := downloadFileIfChanged(releaseUrl, releaseFile+ORIGINAL_FILE_SUFFIX)
+		if _, err := os.Stat(releaseFile); changed || err != nil || previous.PatchedFor != config.Patch.ServerAddress {
+			log.Printf("[*] Patching desktop release %s for %s", release.LatestVersion, config.Patch.ServerAddress)
+			rules, err := loadPatchRules(config.Patch.Rules)
+			if err != nil {
+				return err
+			}
+			if err := patchGzippedAsar(releaseFile+ORIGINAL_FILE_SUFFIX, releaseFile, rules, config.Patch.ServerAddress); err != nil {
+				return err
+			}
+			provenance.recordDerived(releaseFile, releaseFile+ORIGINAL_FILE_SUFFIX)
+		}
+		mirrored.PatchedFor = config.Patch.ServerAddress
+	}
+
+	if _, err := os.Stat(releaseFile); err != nil {
+		return fmt.Errorf("[!] Error downloading desktop release: %s, %s", release.LatestVersion, err)
+	}
+
+	info, err := json.MarshalIndent(mirrored, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(filepath.Join(desktopReleaseFolder(downloadFolder, release.LatestVersion), DESKTOP_RELEASE_INFO_FILE), info, 0644)
+}
+
+// The served channel and the versions of every rollout group are kept
+// whatever their age, rollout back selects older mirrored versions
+func pruneDesktopReleases(downloadFolder string, upstream DesktopReleases, rolloutPath string) error {
+	keep := append([]string{upstream.LatestVersion, resolveChannelVersion(config.DesktopReleases.Channel, upstream)}, config.DesktopReleases.Pinned...)
+	if upstream.Beta != nil && config.DesktopReleases.Beta {
+		keep = append(keep, upstream.Beta.LatestVersion)
+	}
+	rollout, err := loadRollout(rolloutPath)
+	if err != nil {
+		return err
+	}
+	keep = append(keep, resolveChannelVersion(rollout.Default, upstream))
+	for _, group := range rollout.Groups {
+		keep = append(keep, resolveChannelVersion(group.Version, upstream))
+	}
+
+	stable := 0
+	for _, release := range listMirroredDesktopReleases(downloadFolder) {
+		if release.IsBeta {
+			continue
+		}
+		if stable < config.DesktopReleases.Keep {
+			keep = append(keep, release.LatestVersion)
+		}
+		stable++
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(downloadFolder, OBSIDIAN_GITHUB_PATH, "releases", "download"))
+	for _, entry := range entries {
+		version := strings.TrimPrefix(entry.Name(), "v")
+		if !entry.IsDir() || lo.Contains(keep, version) {
+			continue
+		}
+		log.Printf("[*] Removing old desktop release: %s", version)
+		if err := os.RemoveAll(filepath.Join(downloadFolder, OBSIDIAN_GITHUB_PATH, "releases", "download", entry.Name())); err != nil {
+			log.Printf("%v\n\n", err)
+		}
+	}
+	return nil
+}
+
+func servedDesktopRelease(downloadFolder string, version string) (DesktopRelease, error) {
+	release, err := readMirroredDesktopRelease(downloadFolder, version)
+	if err != nil {
+		return DesktopRelease{}, fmt.Errorf("[!] Desktop release is not mirrored: %s, %s", version, err)
+	}
+	release.DownloadUrl = fmt.Sprintf("https://github.com/%s", desktopReleasePath(version))
+	if config.Patch.ServerAddress != "" {
+		release.DownloadUrl = patchServerAddresses(release.DownloadUrl, config.Patch.ServerAddress)
+	}
+	return release.DesktopRelease, nil
+}
+
+func resolveChannelVersion(channel string, upstream DesktopReleases) string {
+	switch channel {
+	case "", "latest":
+		return upstream.LatestVersion
+	case "beta":
+		if upstream.Beta != nil {
+			return upstream.Beta.LatestVersion
+		}
+		return upstream.LatestVersion
+	}
+	return channel
+}
+
+func buildServedDesktopReleases(downloadFolder string, upstream DesktopReleases, channel string) (DesktopReleases, error) {
+	served := DesktopReleases{MinimumVersion: upstream.MinimumVersion}
+	version := resolveChannelVersion(channel, upstream)
+	release, err := servedDesktopRelease(downloadFolder, version)
+	if err != nil {
+		return served, err
+	}
+	served.DesktopRelease = release
+
+	if upstream.Beta != nil && config.DesktopReleases.Beta && compareVersions(upstream.Beta.LatestVersion, version) > 0 {
+		if beta, err := servedDesktopRelease(downloadFolder, upstream.Beta.LatestVersion); err == nil {
+			served.Beta = &beta
+		}
+	}
+	return served, nil
+}
+
+func writeDesktopReleases(downloadFolder string, releasesFilename string, releases DesktopReleases) error {
+	data, err := json.MarshalIndent(releases, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(filepath.Join(downloadFolder, OBSIDIAN_GITHUB_PATH, releasesFilename), data, 0644)
+}
+
+func writeServedDesktopReleases(downloadFolder string, upstream DesktopReleases) error {
+	upstreamFile := filepath.Join(downloadFolder, OBSIDIAN_GITHUB_PATH, DESKTOP_RELEASES_UPSTREAM_FILE)
+	servedFile := filepath.Join(downloadFolder, OBSIDIAN_GITHUB_PATH, DESKTOP_RELEASES_FILE)
+	if err := writeDesktopReleases(downloadFolder, DESKTOP_RELEASES_UPSTREAM_FILE, upstream); err != nil {
+		return err
+	}
+	provenance.recordGitFile(upstreamFile, OBSIDIAN_GITHUB_PATH, DESKTOP_RELEASES_FILE)
+
+	served, err := buildServedDesktopReleases(downloadFolder, upstream, config.DesktopReleases.Channel)
+	if err != nil {
+		return err
+	}
+	if err := writeDesktopReleases(downloadFolder, DESKTOP_RELEASES_FILE, served); err != nil {
+		return err
+	}
+	provenance.recordDerived(servedFile, upstreamFile)
+	return nil
+}
+
+func syncDesktopReleases(downloadFolder string, rolloutPath string) error {
+	upstream, err := readDesktopReleases(downloadFolder, DESKTOP_RELEASES_FILE)
+	if err != nil {
+		return fmt.Errorf("[!] Error reading desktop releases: %s", err)
+	}
+
+	if err := downloadDesktopRelease(downloadFolder, upstream.DesktopRelease, false); err != nil {
+		return err
+	}
+	if upstream.Beta != nil && config.DesktopReleases.Beta {
+		if err := downloadDesktopRelease(downloadFolder, *upstream.Beta, true); err != nil {
+			log.Printf("%v\n\n", err)
+		}
+	}
+	for _, version := range config.DesktopReleases.Pinned {
+		if _, err := readMirroredDesktopRelease(downloadFolder, version); err == nil {
+			continue
+		}
+		if err := downloadDesktopRelease(downloadFolder, DesktopRelease{LatestVersion: version}, false); err != nil {
+			log.Printf("%v\n\n", err)
+		}
+	}
+
+	if err := pruneDesktopReleases(downloadFolder, upstream, rolloutPath); err != nil {
+		return err
+	}
+	// Kept releases patched for another server, or mirrored before patching
+	// was enabled, are patched again from the original asar
+	for _, release := range listMirroredDesktopReleases(downloadFolder) {
+		if release.PatchedFor == config.Patch.ServerAddress {
+			continue
+		}
+		if err := downloadDesktopRelease(downloadFolder, release.DesktopRelease, release.IsBeta); err != nil {
+			log.Printf("%v\n\n", err)
+		}
+	}
+	if config.Installers.Enabled {
+		for _, release := range listMirroredDesktopReleases(downloadFolder) {
+			if err := downloadDesktopInstallers(downloadFolder, release.LatestVersion); err != nil {
+				log.Printf("%v\n\n", err)
+			}
+		}
+	}
+	if err := writeDownloadsList(downloadFolder); err != nil {
+		log.Printf("%v\n\n", err)
+	}
+	return writeServedDesktopReleases(downloadFolder, upstream)
+}
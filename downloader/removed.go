@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+const (
+	PLUGINS_REMOVED_JSON_FILENAME = "community-plugins-removed.json"
+	REMOVED_KEEP                  = "keep"
+	REMOVED_QUARANTINE            = "quarantine"
+	REMOVED_DELETE                = "delete"
+)
+
+var (
+	REMOVED_ACTIONS = []string{REMOVED_KEEP, REMOVED_QUARANTINE, REMOVED_DELETE}
+	// Github owners can't have underscores, so this never clashes with a quarantined version
+	REMOVED_PLUGINS_FOLDER = filepath.Join(QUARANTINE_FOLDER, "_removed")
+)
+
+type RemovedPlugin struct {
+	Id     string `json:"id"`
+	Name   string `json:"name"`
+	Author string `json:"author"`
+	Repo   string `json:"repo"`
+	Reason string `json:"reason"`
+}
+
+type PluginRemoval struct {
+	Reason   string `json:"reason"`
+	ForCause bool   `json:"forCause,omitempty"`
+	Action   string `json:"action"`
+	Listed   bool   `json:"listed,omitempty"`
+}
+
+func readRemovedPlugins(downloadFolder string) ([]RemovedPlugin, error) {
+	var plugins []RemovedPlugin
+	err := readJsonFile(filepath.Join(downloadFolder, OBSIDIAN_GITHUB_PATH, PLUGINS_REMOVED_JSON_FILENAME), &plugins)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return plugins, err
+}
+
+func removedForCause(reason string) bool {
+	reason = strings.ToLower(reason)
+	return lo.SomeBy(config.RemovedPlugins.Causes, func(cause string) bool {
+		return cause != "" && strings.Contains(reason, strings.ToLower(cause))
+	})
+}
+
+func removedPluginAction(plugin RemovedPlugin) string {
+	if lo.Contains(config.RemovedPlugins.Allow, plugin.Id) {
+		return REMOVED_KEEP
+	}
+	return config.RemovedPlugins.Action
+}
+
+// Kept plugins stay mirrored for the clients that have them installed, but
+// only the ones an admin allowed are listed again
+func removedPluginListed(plugin RemovedPlugin) bool {
+	return lo.Contains(config.RemovedPlugins.Allow, plugin.Id)
+}
+
+// Plugin ids of the repos mirrored or quarantined as removed
+func mirroredPluginRepos(downloadFolder string) map[string]string {
+	repos := map[string]string{}
+	for _, folder := range []string{REMOVED_PLUGINS_FOLDER, downloadFolder} {
+		manifests, _ := filepath.Glob(filepath.Join(folder, "*", "*", "manifest.json"))
+		for _, manifestPath := range manifests {
+			repoFolder := filepath.Dir(manifestPath)
+			relativePath, _ := filepath.Rel(folder, repoFolder)
+			repo := filepath.ToSlash(relativePath)
+			manifest, err := readManifest(repoFolder)
+			if err != nil || manifest.Id == "" || repo == OBSIDIAN_GITHUB_PATH {
+				continue
+			}
+			repos[manifest.Id] = repo
+		}
+	}
+	return repos
+}
+
+// Plugins can be added back upstream without leaving the removed list. Older
+// entries have no repo, they are found by id among the mirrored manifests
+func removedPlugins(downloadFolder string) ([]RemovedPlugin, error) {
+	removed, err := readRemovedPlugins(downloadFolder)
+	if err != nil || len(removed) == 0 {
+		return nil, err
+	}
+	plugins, err := readUpstreamCommunityPlugins(downloadFolder)
+	if err != nil {
+		return nil, err
+	}
+	if lo.SomeBy(removed, func(plugin RemovedPlugin) bool { return plugin.Repo == "" }) {
+		repos := mirroredPluginRepos(downloadFolder)
+		for i := range removed {
+			if removed[i].Repo == "" {
+				removed[i].Repo = repos[removed[i].Id]
+			}
+		}
+	}
+	listed := lo.Map(plugins, func(plugin CommunityPlugin, _ int) string { return plugin.Repo })
+	return lo.Filter(removed, func(plugin RemovedPlugin, _ int) bool {
+		return plugin.Repo != "" && !lo.Contains(listed, plugin.Repo)
+	}), nil
+}
+
+// Entries the served plugins list gets back for the removed plugins still mirrored
+func listedRemovedPlugins(downloadFolder string) ([]json.RawMessage, error) {
+	removed, err := removedPlugins(downloadFolder)
+	if err != nil {
+		return nil, err
+	}
+	var entries []json.RawMessage
+	for _, plugin := range removed {
+		if !removedPluginListed(plugin) {
+			continue
+		}
+		manifest, err := readManifest(filepath.Join(downloadFolder, plugin.Repo))
+		if err != nil {
+			continue
+		}
+		entry, err := json.Marshal(CommunityPlugin{Id: plugin.Id, Name: plugin.Name, Author: plugin.Author, Description: manifest.Description, Repo: plugin.Repo})
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func moveRepoFolder(from string, to string) error {
+	if err := os.RemoveAll(to); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	os.Remove(filepath.Dir(from))
+	return nil
+}
+
+func applyRemovedPluginAction(plugin RemovedPlugin, action string, repoFolder string, quarantinedFolder string) error {
+	_, mirroredErr := os.Stat(repoFolder)
+	_, quarantinedErr := os.Stat(quarantinedFolder)
+
+	switch action {
+	case REMOVED_KEEP:
+		if mirroredErr != nil && quarantinedErr == nil {
+			log.Printf("[*] Restoring %s from quarantine", plugin.Repo)
+			return moveRepoFolder(quarantinedFolder, repoFolder)
+		}
+	case REMOVED_QUARANTINE:
+		if mirroredErr == nil {
+			log.Printf("[*] Quarantining %s, removed from the community list", plugin.Repo)
+			return moveRepoFolder(repoFolder, quarantinedFolder)
+		}
+	case REMOVED_DELETE:
+		if mirroredErr == nil || quarantinedErr == nil {
+			log.Printf("[*] Deleting %s, removed from the community list", plugin.Repo)
+		}
+		for _, folder := range []string{repoFolder, quarantinedFolder} {
+			if err := os.RemoveAll(folder); err != nil {
+				return err
+			}
+			os.Remove(filepath.Dir(folder))
+		}
+	}
+	return nil
+}
+
+func checkRemovedPluginsAction() error {
+	if !lo.Contains(REMOVED_ACTIONS, config.RemovedPlugins.Action) {
+		return fmt.Errorf("[!] Unknown removedPlugins action: %s, expected one of %s", config.RemovedPlugins.Action, strings.Join(REMOVED_ACTIONS, ", "))
+	}
+	return nil
+}
+
+// The mirror only ever adds plugins, this keeps, quarantines or deletes the
+// ones dropped upstream and flags the ones removed for cause
+func handleRemovedPlugins(downloadFolder string) error {
+	removed, err := removedPlugins(downloadFolder)
+	if err != nil {
+		return err
+	}
+
+	for _, plugin := range removed {
+		repoFolder := filepath.Join(downloadFolder, plugin.Repo)
+		quarantinedFolder := filepath.Join(REMOVED_PLUGINS_FOLDER, plugin.Repo)
+		_, mirroredErr := os.Stat(repoFolder)
+		_, quarantinedErr := os.Stat(quarantinedFolder)
+		if mirroredErr != nil && quarantinedErr != nil {
+			continue
+		}
+
+		forCause := removedForCause(plugin.Reason)
+		if forCause {
+			log.Printf("[!] %s was removed from the community list for cause: %s", plugin.Repo, plugin.Reason)
+		}
+		action := removedPluginAction(plugin)
+		if err := applyRemovedPluginAction(plugin, action, repoFolder, quarantinedFolder); err != nil {
+			log.Printf("[!] Error handling removed plugin: %s, %s\n\n", plugin.Repo, err)
+		}
+		syncReport.update(plugin.Repo, "plugin", func(report *RepoReport) {
+			report.Removal = &PluginRemoval{Reason: plugin.Reason, ForCause: forCause, Action: action, Listed: removedPluginListed(plugin)}
+		})
+	}
+	return nil
+}
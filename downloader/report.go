@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const LATEST_REPORT_FILE = "latest.json"
+
+var REPORTS_FOLDER = filepath.Join(".", "reports")
+
+type RepoReport struct {
+	Repo            string                 `json:"repo"`
+	Kind            string                 `json:"kind"`
+	Version         string                 `json:"version,omitempty"`
+	PreviousVersion string                 `json:"previousVersion,omitempty"`
+	Quarantined     bool                   `json:"quarantined,omitempty"`
+	Review          string                 `json:"review,omitempty"`
+	Findings        []ScanFinding          `json:"findings,omitempty"`
+	CapabilityDiff  *CapabilityDiff        `json:"capabilityDiff,omitempty"`
+	Offline         *OfflineClassification `json:"offline,omitempty"`
+	Cdn             []string               `json:"cdn,omitempty"`
+	Removal         *PluginRemoval         `json:"removal,omitempty"`
+}
+
+type SyncReport struct {
+	sync.Mutex `json:"-"`
+	StartedAt  time.Time              `json:"startedAt"`
+	FinishedAt time.Time              `json:"finishedAt"`
+	Commit     string                 `json:"commit,omitempty"`
+	Dedup      *DedupStats            `json:"-"`
+	Repos      map[string]*RepoReport `json:"-"`
+}
+
+var syncReport = &SyncReport{Repos: map[string]*RepoReport{}}
+
+func (report *SyncReport) update(repo string, kind string, update func(repoReport *RepoReport)) {
+	report.Lock()
+	defer report.Unlock()
+	repoReport, ok := report.Repos[repo]
+	if !ok {
+		repoReport = &RepoReport{Repo: repo, Kind: kind}
+		report.Repos[repo] = repoReport
+	}
+	update(repoReport)
+}
+
+func (report *SyncReport) MarshalJSON() ([]byte, error) {
+	repos := make([]*RepoReport, 0, len(report.Repos))
+	for _, repoReport := range report.Repos {
+		repos = append(repos, repoReport)
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Repo < repos[j].Repo })
+
+	return json.Marshal(struct {
+		StartedAt  time.Time     `json:"startedAt"`
+		FinishedAt time.Time     `json:"finishedAt"`
+		Commit     string        `json:"commit,omitempty"`
+		Dedup      *DedupStats   `json:"dedup,omitempty"`
+		Repos      []*RepoReport `json:"repos"`
+	}{report.StartedAt, report.FinishedAt, report.Commit, report.Dedup, repos})
+}
+
+func (report *SyncReport) write() (string, error) {
+	report.Lock()
+	defer report.Unlock()
+	report.FinishedAt = time.Now().UTC()
+
+	if err := os.MkdirAll(REPORTS_FOLDER, 0755); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	reportPath := filepath.Join(REPORTS_FOLDER, "sync-"+report.StartedAt.Format("20060102-150405")+".json")
+	if err := os.WriteFile(reportPath, data, 0644); err != nil {
+		return "", err
+	}
+	return reportPath, os.WriteFile(filepath.Join(REPORTS_FOLDER, LATEST_REPORT_FILE), data, 0644)
+}
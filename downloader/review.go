@@ -0,0 +1,461 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+const (
+	REVIEWS_FILE         = "reviews.json"
+	UPSTREAM_FILE_SUFFIX = ".upstream.json"
+	REVIEW_PENDING       = "pending"
+	REVIEW_APPROVED      = "approved"
+	REVIEW_REJECTED      = "rejected"
+)
+
+type Review struct {
+	Repo            string          `json:"repo"`
+	Kind            string          `json:"kind"`
+	Version         string          `json:"version"`
+	PreviousVersion string          `json:"previousVersion,omitempty"`
+	Status          string          `json:"status"`
+	QueuedAt        time.Time       `json:"queuedAt"`
+	DecidedBy       string          `json:"decidedBy,omitempty"`
+	DecidedAt       *time.Time      `json:"decidedAt,omitempty"`
+	Comment         string          `json:"comment,omitempty"`
+	Findings        []ScanFinding   `json:"findings,omitempty"`
+	CapabilityDiff  *CapabilityDiff `json:"capabilityDiff,omitempty"`
+	Digest          string          `json:"digest,omitempty"`
+}
+
+var reviewsLock sync.Mutex
+
+func reviewsPath() string {
+	return filepath.Join(QUARANTINE_FOLDER, REVIEWS_FILE)
+}
+
+func loadReviews() ([]*Review, error) {
+	var reviews []*Review
+	data, err := os.ReadFile(reviewsPath())
+	if os.IsNotExist(err) {
+		return reviews, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &reviews); err != nil {
+		return nil, fmt.Errorf("[!] Error parsing reviews: %s, %s", reviewsPath(), err)
+	}
+	return reviews, nil
+}
+
+func updateReviews(update func(reviews []*Review) ([]*Review, error)) error {
+	reviewsLock.Lock()
+	defer reviewsLock.Unlock()
+
+	reviews, err := loadReviews()
+	if err != nil {
+		return err
+	}
+	if reviews, err = update(reviews); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(QUARANTINE_FOLDER, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(reviews, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(reviewsPath(), data, 0644)
+}
+
+func findReview(reviews []*Review, repo string, version string) *Review {
+	review, _ := lo.Find(reviews, func(review *Review) bool { return review.Repo == repo && review.Version == version })
+	return review
+}
+
+// Digest of the reviewed files, the names are hashed without the .orig suffix
+func filesDigest(filePaths []string) string {
+	hash := sha256.New()
+	for _, filePath := range filePaths {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(hash, "%s %d\n", strings.TrimSuffix(filepath.Base(filePath), ORIGINAL_FILE_SUFFIX), len(data))
+		hash.Write(data)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// A version republished with other files is queued again, rejected ones
+// recorded before digests were kept stay rejected
+func queueReview(review Review) (string, error) {
+	status := REVIEW_PENDING
+	err := updateReviews(func(reviews []*Review) ([]*Review, error) {
+		if existing := findReview(reviews, review.Repo, review.Version); existing != nil {
+			if existing.Status != REVIEW_PENDING && existing.Digest != review.Digest && (existing.Status == REVIEW_APPROVED || existing.Digest != "") {
+				existing.Status = REVIEW_PENDING
+				existing.QueuedAt = time.Now().UTC()
+				existing.DecidedBy = ""
+				existing.DecidedAt = nil
+				existing.Comment = ""
+			}
+			status = existing.Status
+			if existing.Status == REVIEW_PENDING {
+				existing.PreviousVersion = review.PreviousVersion
+				existing.Findings = review.Findings
+				existing.CapabilityDiff = review.CapabilityDiff
+				existing.Digest = review.Digest
+			}
+			return reviews, nil
+		}
+		review.Status = REVIEW_PENDING
+		review.QueuedAt = time.Now().UTC()
+		return append(reviews, &review), nil
+	})
+	return status, err
+}
+
+func readRepoFiles(repoFolder string, files []string) map[string][]byte {
+	contents := map[string][]byte{}
+	for _, file := range files {
+		if data, err := os.ReadFile(filepath.Join(repoFolder, file)); err == nil {
+			contents[file] = data
+		}
+	}
+	return contents
+}
+
+func reviewThemeVersion(repo Repo, repoFolder string, previousFiles map[string][]byte) error {
+	if !config.Review.Enabled {
+		return nil
+	}
+	manifest, err := readManifest(repoFolder)
+	if err != nil || manifest.Version == "" {
+		return nil
+	}
+	var previous Manifest
+	json.Unmarshal(previousFiles["manifest.json"], &previous)
+	// Upstream can change theme.css without bumping the version, the files are compared
+	files := downloadedFileNames(THEMES_FILES)
+	if previous.Version == manifest.Version && reflect.DeepEqual(readRepoFiles(repoFolder, files), previousFiles) {
+		return nil
+	}
+
+	digest := filesDigest(lo.Map(files, func(file string, _ int) string { return filepath.Join(repoFolder, file) }))
+	status, err := queueReview(Review{Repo: repo.Repo, Kind: "theme", Version: manifest.Version, PreviousVersion: previous.Version, Digest: digest})
+	if err != nil {
+		return err
+	}
+	syncReport.update(repo.Repo, "theme", func(report *RepoReport) {
+		report.Version = manifest.Version
+		if previous.Version != manifest.Version {
+			report.PreviousVersion = previous.Version
+		}
+		report.Quarantined = status != REVIEW_APPROVED
+		report.Review = status
+	})
+	if status == REVIEW_APPROVED {
+		return nil
+	}
+
+	if status == REVIEW_PENDING {
+		stagedFolder := quarantineFolder(repo.Repo, manifest.Version)
+		os.RemoveAll(stagedFolder)
+		if err := os.MkdirAll(stagedFolder, 0755); err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := copyFile(filepath.Join(repoFolder, file), filepath.Join(stagedFolder, file)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	for _, file := range files {
+		filePath := filepath.Join(repoFolder, file)
+		data, ok := previousFiles[file]
+		if !ok {
+			os.Remove(filePath)
+			continue
+		}
+		if err := replaceFile(filePath, data, 0644); err != nil {
+			return err
+		}
+		provenance.recordFile(filePath, fmt.Sprintf("https://raw.githubusercontent.com/%s/HEAD/%s", repo.Repo, strings.TrimSuffix(file, ORIGINAL_FILE_SUFFIX)))
+	}
+	return nil
+}
+
+func approveReview(downloadFolder string, review *Review) error {
+	repoFolder := filepath.Join(downloadFolder, review.Repo)
+	stagedFolder := quarantineFolder(review.Repo, review.Version)
+	if _, err := os.Stat(stagedFolder); err != nil {
+		return fmt.Errorf("[!] Quarantined files are missing, run sync again: %s", stagedFolder)
+	}
+
+	if review.Kind == "plugin" {
+		if err := publishPluginRelease(Repo{Repo: review.Repo}, repoFolder, review.Version, stagedFolder); err != nil {
+			return err
+		}
+		manifestPath := filepath.Join(repoFolder, "manifest.json")
+		releaseManifestPath := filepath.Join(pluginReleaseFolder(repoFolder, review.Version), "manifest.json")
+		if err := copyFile(releaseManifestPath, manifestPath); err != nil {
+			return err
+		}
+		provenance.recordDerived(manifestPath, releaseManifestPath)
+		_, err := mirrorPluginCdn(Repo{Repo: review.Repo, isPlugin: true}, repoFolder)
+		return err
+	}
+
+	if err := os.MkdirAll(repoFolder, 0755); err != nil {
+		return err
+	}
+	for _, file := range downloadedFileNames(THEMES_FILES) {
+		filePath := filepath.Join(repoFolder, file)
+		if err := copyFile(filepath.Join(stagedFolder, file), filePath); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		provenance.recordFile(filePath, fmt.Sprintf("https://raw.githubusercontent.com/%s/HEAD/%s", review.Repo, strings.TrimSuffix(file, ORIGINAL_FILE_SUFFIX)))
+	}
+	removeQuarantined(stagedFolder)
+	if err := mirrorThemeAssets(Repo{Repo: review.Repo, isTheme: true}, repoFolder); err != nil {
+		return err
+	}
+	return mirrorReadme(Repo{Repo: review.Repo, isTheme: true}, repoFolder)
+}
+
+func decideReview(downloadFolder string, repo string, version string, status string, decidedBy string, comment string) (*Review, error) {
+	var decided *Review
+	err := updateReviews(func(reviews []*Review) ([]*Review, error) {
+		review := findReview(reviews, repo, version)
+		if review == nil {
+			return nil, fmt.Errorf("[!] No review for %s %s", repo, version)
+		}
+		if review.Status != REVIEW_PENDING {
+			return nil, fmt.Errorf("[!] %s %s was already %s by %s", repo, version, review.Status, review.DecidedBy)
+		}
+
+		if status == REVIEW_APPROVED {
+			if err := approveReview(downloadFolder, review); err != nil {
+				return nil, err
+			}
+		} else {
+			removeQuarantined(quarantineFolder(repo, version))
+		}
+
+		now := time.Now().UTC()
+		review.Status = status
+		review.DecidedBy = decidedBy
+		review.DecidedAt = &now
+		review.Comment = comment
+		decided = review
+		return reviews, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if filtersCommunityLists() {
+		if err := writeServedCommunityLists(downloadFolder); err != nil {
+			return decided, err
+		}
+	}
+	if err := writeCatalog(downloadFolder, CATALOG_FOLDER); err != nil {
+		log.Printf("%v\n\n", err)
+	}
+	if err := provenance.flush(); err != nil {
+		return decided, err
+	}
+	if err := writeMirrorIndex(downloadFolder); err != nil {
+		return decided, err
+	}
+	if _, err := storeMirrorBlobs(downloadFolder); err != nil {
+		return decided, err
+	}
+	_, err = writeSearchIndex(downloadFolder, SEARCH_INDEX_FILE)
+	return decided, err
+}
+
+func upstreamFilePath(servedFile string) string {
+	return strings.TrimSuffix(servedFile, ".json") + UPSTREAM_FILE_SUFFIX
+}
+
+func filtersCommunityLists() bool {
+	return config.Review.Enabled || config.Offline.HideOnlineOnly || len(config.RemovedPlugins.Allow) > 0
+}
+
+// Upstream copies are dropped once nothing is filtered, a stale one would be read instead of the served list
+func keepUpstreamCommunityLists(downloadFolder string) error {
+	for _, filename := range []string{PLUGINS_JSON_FILENAME, THEMES_JSON_FILENAME} {
+		servedFile := filepath.Join(downloadFolder, OBSIDIAN_GITHUB_PATH, filename)
+		upstreamFile := upstreamFilePath(servedFile)
+		if !filtersCommunityLists() {
+			if err := os.Remove(upstreamFile); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if err := copyFile(servedFile, upstreamFile); err != nil {
+			return err
+		}
+		provenance.recordGitFile(upstreamFile, OBSIDIAN_GITHUB_PATH, filename)
+	}
+	return nil
+}
+
+// Community lists only show repos that have an approved version, and
+// plugins that can work offline when online-only ones are hidden.
+// Removed plugins allowed by an admin are listed again
+func writeServedCommunityLists(downloadFolder string) error {
+	reviews, err := loadReviews()
+	if err != nil {
+		return err
+	}
+	approved := lo.Uniq(lo.FilterMap(reviews, func(review *Review, _ int) (string, bool) {
+		return review.Repo, review.Status == REVIEW_APPROVED
+	}))
+	unapproved := lo.Without(lo.Uniq(lo.Map(reviews, func(review *Review, _ int) string { return review.Repo })), approved...)
+
+	for _, filename := range []string{PLUGINS_JSON_FILENAME, THEMES_JSON_FILENAME} {
+		servedFile := filepath.Join(downloadFolder, OBSIDIAN_GITHUB_PATH, filename)
+		upstreamFile := upstreamFilePath(servedFile)
+
+		var entries []json.RawMessage
+		if err := readJsonFile(upstreamFile, &entries); err != nil {
+			return err
+		}
+		if filename == PLUGINS_JSON_FILENAME {
+			removed, err := listedRemovedPlugins(downloadFolder)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, removed...)
+		}
+		served := lo.Filter(entries, func(entry json.RawMessage, _ int) bool {
+			var item struct {
+				Repo string
+			}
+			json.Unmarshal(entry, &item)
+			if config.Offline.HideOnlineOnly && filename == PLUGINS_JSON_FILENAME {
+				classification, err := classifyPluginOffline(filepath.Join(downloadFolder, item.Repo), item.Repo)
+				if err == nil && classification.Class == OFFLINE_ONLINE_ONLY {
+					return false
+				}
+			}
+			if !lo.Contains(unapproved, item.Repo) {
+				return true
+			}
+			_, err := os.Stat(filepath.Join(downloadFolder, item.Repo, "manifest.json"))
+			return err == nil
+		})
+
+		data, err := json.MarshalIndent(served, "", "\t")
+		if err != nil {
+			return err
+		}
+		if err := replaceFile(servedFile, data, 0644); err != nil {
+			return err
+		}
+		provenance.recordDerived(servedFile, upstreamFile)
+	}
+	return nil
+}
+
+func printReview(review *Review) {
+	decision := ""
+	if review.DecidedAt != nil {
+		decision = fmt.Sprintf(" by %s at %s", review.DecidedBy, review.DecidedAt.Format(time.RFC3339))
+	}
+	fmt.Printf("%-8s %-6s %s %s -> %s%s\n", review.Status, review.Kind, review.Repo, lo.Ternary(review.PreviousVersion == "", "new", review.PreviousVersion), review.Version, decision)
+	if len(review.Findings) > 0 {
+		fmt.Printf("         findings: %s\n", describeFindings(review.Findings))
+	}
+}
+
+func reviewCommand(args []string) error {
+	flags := flag.NewFlagSet("review", flag.ExitOnError)
+	status := flags.String("status", REVIEW_PENDING, "Reviews to list, pending, approved, rejected or all")
+	decidedBy := flags.String("by", os.Getenv("USER"), "Reviewer name recorded with the decision")
+	comment := flags.String("comment", "", "Comment recorded with the decision")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: review [flags] list | show <owner/repo> <version> | approve <owner/repo> <version> | reject <owner/repo> <version>")
+		flags.PrintDefaults()
+	}
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+
+	action := flags.Arg(0)
+	if action == "" || action == "list" {
+		reviews, err := loadReviews()
+		if err != nil {
+			return err
+		}
+		for _, review := range reviews {
+			if *status == "all" || review.Status == *status {
+				printReview(review)
+			}
+		}
+		return nil
+	}
+
+	if flags.NArg() != 3 {
+		flags.Usage()
+		return fmt.Errorf("[!] Missing repo or version")
+	}
+	repo, version := flags.Arg(1), flags.Arg(2)
+
+	switch action {
+	case "show":
+		reviews, err := loadReviews()
+		if err != nil {
+			return err
+		}
+		review := findReview(reviews, repo, version)
+		if review == nil {
+			return fmt.Errorf("[!] No review for %s %s", repo, version)
+		}
+		printReview(review)
+		for _, finding := range review.Findings {
+			fmt.Printf("\n[%s] %s: %s (%d matches)\n", finding.Severity, finding.Rule, finding.Description, finding.Count)
+			for _, sample := range finding.Samples {
+				fmt.Printf("    %s\n", sample)
+			}
+		}
+		if review.CapabilityDiff != nil {
+			fmt.Println()
+			writeCapabilityDiff(os.Stdout, repo, *review.CapabilityDiff)
+		}
+		return nil
+	case "approve", "reject":
+		if *decidedBy == "" {
+			return fmt.Errorf("[!] Missing reviewer name, use -by")
+		}
+		review, err := decideReview(DOWNLOAD_FOLDER, repo, version, lo.Ternary(action == "approve", REVIEW_APPROVED, REVIEW_REJECTED), *decidedBy, *comment)
+		if err != nil {
+			return err
+		}
+		printReview(review)
+		return nil
+	}
+
+	flags.Usage()
+	return fmt.Errorf("[!] Unknown review action: %s", action)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+const (
+	ROLLOUT_FILENAME      = "rollout.json"
+	ROLLOUT_DEFAULT_GROUP = "default"
+)
+
+// Arguments of each rollout action, the action included
+var ROLLOUT_ACTION_ARGS = map[string]int{"list": 1, "set": 3, "forward": 2, "back": 2}
+
+type RolloutGroup struct {
+	Name    string   `json:"name"`
+	Cidrs   []string `json:"cidrs,omitempty"`
+	Users   []string `json:"users,omitempty"`
+	Percent int      `json:"percent,omitempty"`
+	Version string   `json:"version"`
+}
+
+type Rollout struct {
+	Default string         `json:"default"`
+	Groups  []RolloutGroup `json:"groups"`
+}
+
+func loadRollout(rolloutPath string) (Rollout, error) {
+	rollout := Rollout{Default: config.DesktopReleases.Channel}
+	file, err := os.Open(rolloutPath)
+	if os.IsNotExist(err) {
+		return rollout, nil
+	}
+	if err != nil {
+		return rollout, err
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(&rollout); err != nil {
+		return rollout, fmt.Errorf("[!] Error parsing rollout: %s, %s", rolloutPath, err)
+	}
+	return rollout, nil
+}
+
+func saveRollout(rolloutPath string, rollout Rollout) error {
+	data, err := json.MarshalIndent(rollout, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(rolloutPath, data, 0644)
+}
+
+func clientBucket(clientIp string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(clientIp))
+	return int(hash.Sum32() % 100)
+}
+
+func (group RolloutGroup) matches(clientIp net.IP, identity string) bool {
+	for _, cidr := range group.Cidrs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && clientIp != nil && network.Contains(clientIp) {
+			return true
+		}
+	}
+	if identity != "" && lo.Contains(group.Users, identity) {
+		return true
+	}
+	return clientIp != nil && group.Percent > 0 && clientBucket(clientIp.String()) < group.Percent
+}
+
+func (rollout Rollout) versionFor(clientIp net.IP, identity string) (string, string) {
+	for _, group := range rollout.Groups {
+		if group.matches(clientIp, identity) {
+			return group.Name, group.Version
+		}
+	}
+	return ROLLOUT_DEFAULT_GROUP, rollout.Default
+}
+
+func (rollout *Rollout) groupVersion(name string) (*string, error) {
+	if name == ROLLOUT_DEFAULT_GROUP {
+		return &rollout.Default, nil
+	}
+	for i := range rollout.Groups {
+		if rollout.Groups[i].Name == name {
+			return &rollout.Groups[i].Version, nil
+		}
+	}
+	return nil, fmt.Errorf("[!] Unknown rollout group: %s", name)
+}
+
+func stepRolloutVersion(downloadFolder string, current string, forward bool) (string, error) {
+	upstream, err := readDesktopReleases(downloadFolder, DESKTOP_RELEASES_UPSTREAM_FILE)
+	if err != nil {
+		return "", fmt.Errorf("[!] Error reading desktop releases, run sync first: %s", err)
+	}
+	currentVersion := resolveChannelVersion(current, upstream)
+
+	versions := lo.FilterMap(listMirroredDesktopReleases(downloadFolder), func(release mirroredDesktopRelease, _ int) (string, bool) {
+		return release.LatestVersion, !release.IsBeta
+	})
+	if forward {
+		for i := len(versions) - 1; i >= 0; i-- {
+			if compareVersions(versions[i], currentVersion) > 0 {
+				if versions[i] == upstream.LatestVersion {
+					return "latest", nil
+				}
+				return versions[i], nil
+			}
+		}
+		return current, nil
+	}
+
+	for _, version := range versions {
+		if compareVersions(version, currentVersion) < 0 {
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("[!] No mirrored desktop release older than: %s", currentVersion)
+}
+
+func rolloutCommand(args []string) error {
+	flags := flag.NewFlagSet("rollout", flag.ExitOnError)
+	rolloutPath := flags.String("rollout", ROLLOUT_FILENAME, "Rollout file path")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: rollout [flags] list | set <group> <version> | forward <group> | back <group>")
+		flags.PrintDefaults()
+	}
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+
+	action := lo.Ternary(flags.NArg() == 0, "list", flags.Arg(0))
+	argCount, ok := ROLLOUT_ACTION_ARGS[action]
+	if !ok {
+		flags.Usage()
+		return fmt.Errorf("[!] Unknown rollout action: %s", action)
+	}
+	if flags.NArg() > 0 && flags.NArg() != argCount {
+		flags.Usage()
+		return fmt.Errorf("[!] Wrong number of arguments for rollout %s", action)
+	}
+
+	rollout, err := loadRollout(*rolloutPath)
+	if err != nil {
+		return err
+	}
+
+	if action == "list" {
+		fmt.Printf("%-20s %s\n", ROLLOUT_DEFAULT_GROUP, rollout.Default)
+		for _, group := range rollout.Groups {
+			var selectors []string
+			selectors = append(selectors, group.Cidrs...)
+			selectors = append(selectors, lo.Map(group.Users, func(user string, _ int) string { return "user:" + user })...)
+			if group.Percent > 0 {
+				selectors = append(selectors, fmt.Sprintf("%d%%", group.Percent))
+			}
+			fmt.Printf("%-20s %-10s %s\n", group.Name, group.Version, strings.Join(selectors, ", "))
+		}
+		fmt.Println()
+		for _, release := range listMirroredDesktopReleases(DOWNLOAD_FOLDER) {
+			fmt.Printf("mirrored: %s%s\n", release.LatestVersion, lo.Ternary(release.IsBeta, " (beta)", ""))
+		}
+		return nil
+	}
+
+	version, err := rollout.groupVersion(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "set":
+		if target := flags.Arg(2); target != "latest" && target != "beta" {
+			if _, err := readMirroredDesktopRelease(DOWNLOAD_FOLDER, target); err != nil {
+				return fmt.Errorf("[!] Desktop release is not mirrored: %s", target)
+			}
+		}
+		*version = flags.Arg(2)
+	case "forward", "back":
+		if *version, err = stepRolloutVersion(DOWNLOAD_FOLDER, *version, action == "forward"); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("[*] Group %s is now on %s\n", flags.Arg(1), *version)
+	return saveRollout(*rolloutPath, rollout)
+}
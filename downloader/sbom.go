@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const SBOM_SPEC_VERSION = "1.5"
+
+type SbomHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type SbomLicense struct {
+	License struct {
+		Id   string `json:"id,omitempty"`
+		Name string `json:"name,omitempty"`
+	} `json:"license"`
+}
+
+type SbomReference struct {
+	Type string `json:"type"`
+	Url  string `json:"url"`
+}
+
+type SbomProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type SbomComponent struct {
+	Type               string          `json:"type"`
+	BomRef             string          `json:"bom-ref,omitempty"`
+	Name               string          `json:"name"`
+	Version            string          `json:"version,omitempty"`
+	Author             string          `json:"author,omitempty"`
+	Description        string          `json:"description,omitempty"`
+	Purl               string          `json:"purl,omitempty"`
+	Hashes             []SbomHash      `json:"hashes,omitempty"`
+	Licenses           []SbomLicense   `json:"licenses,omitempty"`
+	ExternalReferences []SbomReference `json:"externalReferences,omitempty"`
+	Properties         []SbomProperty  `json:"properties,omitempty"`
+	Components         []SbomComponent `json:"components,omitempty"`
+}
+
+type Sbom struct {
+	BomFormat    string `json:"bomFormat"`
+	SpecVersion  string `json:"specVersion"`
+	SerialNumber string `json:"serialNumber"`
+	Version      int    `json:"version"`
+	Metadata     struct {
+		Timestamp string `json:"timestamp"`
+		Tools     []struct {
+			Name string `json:"name"`
+		} `json:"tools"`
+	} `json:"metadata"`
+	Components []SbomComponent `json:"components"`
+}
+
+func newSerialNumber() string {
+	uuid := make([]byte, 16)
+	rand.Read(uuid)
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+}
+
+func sbomLicenses(licenseId string) []SbomLicense {
+	if licenseId == "" {
+		return nil
+	}
+	var license SbomLicense
+	license.License.Id = licenseId
+	return []SbomLicense{license}
+}
+
+func sbomFiles(folder string, files []string) []SbomComponent {
+	var components []SbomComponent
+	for _, file := range files {
+		sha, err := fileSha256(filepath.Join(folder, file))
+		if err != nil {
+			continue
+		}
+		components = append(components, SbomComponent{
+			Type:   "file",
+			Name:   file,
+			Hashes: []SbomHash{{Alg: "SHA-256", Content: sha}},
+		})
+	}
+	return components
+}
+
+func repoComponent(kind string, componentType string, repo string, manifest Manifest, author string, description string) SbomComponent {
+	versionSuffix := ""
+	if manifest.Version != "" {
+		versionSuffix = "@" + manifest.Version
+	}
+	return SbomComponent{
+		Type:               componentType,
+		BomRef:             fmt.Sprintf("%s:%s%s", kind, repo, versionSuffix),
+		Name:               manifest.Name,
+		Version:            manifest.Version,
+		Author:             author,
+		Description:        description,
+		Purl:               fmt.Sprintf("pkg:github/%s%s", repo, versionSuffix),
+		ExternalReferences: []SbomReference{{Type: "vcs", Url: fmt.Sprintf("https://github.com/%s", repo)}},
+	}
+}
+
+func buildSbom(downloadFolder string) (Sbom, error) {
+	var sbom Sbom
+	sbom.BomFormat = "CycloneDX"
+	sbom.SpecVersion = SBOM_SPEC_VERSION
+	sbom.SerialNumber = newSerialNumber()
+	sbom.Version = 1
+	sbom.Metadata.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	sbom.Metadata.Tools = append(sbom.Metadata.Tools, struct {
+		Name string `json:"name"`
+	}{"offline-obsidian-server"})
+	sbom.Components = []SbomComponent{}
+
+	plugins, err := readCommunityPlugins(downloadFolder)
+	if err != nil {
+		return sbom, fmt.Errorf("[!] Error reading plugins list, run sync first: %s", err)
+	}
+	for _, plugin := range plugins {
+		repoFolder := filepath.Join(downloadFolder, plugin.Repo)
+		manifest, err := readManifest(repoFolder)
+		if err != nil {
+			continue
+		}
+		releaseFolder := filepath.Join(repoFolder, "releases", "download", manifest.Version)
+		component := repoComponent("plugin", "library", plugin.Repo, manifest, plugin.Author, plugin.Description)
+		component.Licenses = sbomLicenses(repoLicense(repoFolder))
+		component.Properties = []SbomProperty{{Name: "obsidian:kind", Value: "plugin"}, {Name: "obsidian:id", Value: plugin.Id}}
+		component.Components = sbomFiles(releaseFolder, PLUGIN_RELEASE_FILES)
+		sbom.Components = append(sbom.Components, component)
+	}
+
+	themes, err := readCommunityThemes(downloadFolder)
+	if err != nil {
+		return sbom, fmt.Errorf("[!] Error reading themes list, run sync first: %s", err)
+	}
+	for _, theme := range themes {
+		repoFolder := filepath.Join(downloadFolder, theme.Repo)
+		manifest, err := readManifest(repoFolder)
+		if err != nil {
+			continue
+		}
+		component := repoComponent("theme", "library", theme.Repo, manifest, theme.Author, "")
+		component.Licenses = sbomLicenses(repoLicense(repoFolder))
+		component.Properties = []SbomProperty{{Name: "obsidian:kind", Value: "theme"}}
+		component.Components = sbomFiles(repoFolder, THEMES_FILES)
+		sbom.Components = append(sbom.Components, component)
+	}
+
+	for _, release := range listMirroredDesktopReleases(downloadFolder) {
+		releaseFolder := desktopReleaseFolder(downloadFolder, release.LatestVersion)
+		files := []string{filepath.Base(desktopReleasePath(release.LatestVersion))}
+		for _, installer := range readDesktopInstallers(downloadFolder, release.LatestVersion) {
+			files = append(files, installer.Name)
+		}
+		component := repoComponent("desktop", "application", OBSIDIAN_GITHUB_PATH, Manifest{Name: "obsidian", Version: release.LatestVersion}, "Obsidian", "Obsidian desktop app")
+		component.Licenses = []SbomLicense{{}}
+		component.Licenses[0].License.Name = "Obsidian License"
+		component.Properties = []SbomProperty{{Name: "obsidian:kind", Value: "desktop"}}
+		if release.PatchedFor != "" {
+			component.Properties = append(component.Properties, SbomProperty{Name: "obsidian:patchedFor", Value: release.PatchedFor})
+			files = append(files, filepath.Base(desktopReleasePath(release.LatestVersion))+ORIGINAL_FILE_SUFFIX)
+		}
+		component.Components = sbomFiles(releaseFolder, files)
+		sbom.Components = append(sbom.Components, component)
+	}
+	return sbom, nil
+}
+
+func sbomCommand(args []string) error {
+	flags := flag.NewFlagSet("sbom", flag.ExitOnError)
+	output := flags.String("o", "sbom.cdx.json", "Output file, - for stdout")
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+
+	sbom, err := buildSbom(DOWNLOAD_FOLDER)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sbom, "", "  ")
+	if err != nil {
+		return err
+	}
+	if *output == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	log.Printf("[*] Wrote %d components to %s", len(sbom.Components), *output)
+	return os.WriteFile(*output, data, 0644)
+}
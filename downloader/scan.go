@@ -0,0 +1,315 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+const (
+	SCAN_RESULT_FILE    = "scan.json"
+	SCAN_SAMPLES        = 5
+	SCAN_MISSING_SCRIPT = "missing-main-js"
+)
+
+var (
+	QUARANTINE_FOLDER = filepath.Join(".", "quarantine")
+
+	//go:embed scan-rules.json
+	DEFAULT_SCAN_RULES []byte
+
+	SEVERITIES = []string{"info", "low", "medium", "high"}
+	scanRules  []*ScanRule
+)
+
+type ScanRule struct {
+	Id          string `json:"id"`
+	Severity    string `json:"severity"`
+	Description string `json:"description"`
+	Regex       string `json:"regex,omitempty"`
+	Token       string `json:"token,omitempty"`
+	MinCount    int    `json:"minCount,omitempty"`
+	ListMatches bool   `json:"listMatches,omitempty"`
+	regex       *regexp.Regexp
+}
+
+type ScanResult struct {
+	Repo     string        `json:"repo"`
+	Version  string        `json:"version"`
+	Findings []ScanFinding `json:"findings"`
+}
+
+type ScanFinding struct {
+	Rule        string   `json:"rule"`
+	Severity    string   `json:"severity"`
+	Description string   `json:"description"`
+	Count       int      `json:"count"`
+	Samples     []string `json:"samples,omitempty"`
+}
+
+func severityRank(severity string) int {
+	return lo.IndexOf(SEVERITIES, severity)
+}
+
+func loadScanRules(rulesPath string) ([]*ScanRule, error) {
+	var rules []*ScanRule
+	data := DEFAULT_SCAN_RULES
+	if rulesPath != "" {
+		var err error
+		if data, err = os.ReadFile(rulesPath); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("[!] Error parsing scan rules: %s, %s", rulesPath, err)
+	}
+
+	for _, rule := range rules {
+		if severityRank(rule.Severity) < 0 {
+			return nil, fmt.Errorf("[!] Scan rule %s has an unknown severity: %s", rule.Id, rule.Severity)
+		}
+		if (rule.Regex == "") == (rule.Token == "") {
+			return nil, fmt.Errorf("[!] Scan rule %s needs exactly one of regex or token", rule.Id)
+		}
+		pattern := rule.Regex
+		if rule.Token != "" {
+			pattern = regexp.QuoteMeta(rule.Token)
+		}
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("[!] Scan rule %s has an invalid regex: %s", rule.Id, err)
+		}
+		rule.regex = regex
+	}
+	return rules, nil
+}
+
+func scanScript(script string, rules []*ScanRule) []ScanFinding {
+	var findings []ScanFinding
+	for _, rule := range rules {
+		matches := rule.regex.FindAllStringIndex(script, -1)
+		if len(matches) == 0 || len(matches) < rule.MinCount {
+			continue
+		}
+
+		var samples []string
+		if rule.ListMatches {
+			samples = lo.Uniq(lo.Map(matches, func(match []int, _ int) string { return script[match[0]:match[1]] }))
+		} else {
+			for _, match := range matches[:lo.Min([]int{len(matches), SCAN_SAMPLES})] {
+				samples = append(samples, snippet(script, match[0], match[1]))
+			}
+		}
+		findings = append(findings, ScanFinding{
+			Rule:        rule.Id,
+			Severity:    rule.Severity,
+			Description: rule.Description,
+			Count:       len(matches),
+			Samples:     samples,
+		})
+	}
+	return findings
+}
+
+func scanScriptFile(scriptPath string, rules []*ScanRule) ([]ScanFinding, error) {
+	script, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return nil, err
+	}
+	return scanScript(string(script), rules), nil
+}
+
+// A release without main.js can't be scanned, it's held even with the quarantine disabled
+func blockingFindings(findings []ScanFinding) []ScanFinding {
+	threshold := severityRank(config.Scan.QuarantineSeverity)
+	return lo.Filter(findings, func(finding ScanFinding, _ int) bool {
+		return finding.Rule == SCAN_MISSING_SCRIPT || threshold >= 0 && severityRank(finding.Severity) >= threshold
+	})
+}
+
+func describeFindings(findings []ScanFinding) string {
+	return strings.Join(lo.Map(findings, func(finding ScanFinding, _ int) string {
+		return fmt.Sprintf("%s (%s)", finding.Rule, finding.Severity)
+	}), ", ")
+}
+
+func quarantineFolder(repo string, version string) string {
+	return filepath.Join(QUARANTINE_FOLDER, repo, version)
+}
+
+// Removes a quarantined version and its repo and owner folders once empty
+func removeQuarantined(stagedFolder string) {
+	os.RemoveAll(stagedFolder)
+	os.Remove(filepath.Dir(stagedFolder))
+	os.Remove(filepath.Dir(filepath.Dir(stagedFolder)))
+}
+
+func restoreManifest(repo Repo, repoFolder string, previousManifest []byte) error {
+	manifestPath := filepath.Join(repoFolder, "manifest.json")
+	if previousManifest == nil {
+		return os.Remove(manifestPath)
+	}
+	if err := replaceFile(manifestPath, previousManifest, 0644); err != nil {
+		return err
+	}
+	provenance.recordFile(manifestPath, fmt.Sprintf("https://raw.githubusercontent.com/%s/HEAD/manifest.json", repo.Repo))
+	return nil
+}
+
+// The served files of a mirrored version are copied to a new staging folder,
+// main.js under the name it's downloaded to so a rewritten one isn't staged
+func stagePluginRelease(servedFolder string, stagedFolder string) error {
+	if _, err := os.Stat(stagedFolder); err == nil {
+		return nil
+	}
+	entries, err := os.ReadDir(servedFolder)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(stagedFolder, 0755); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), PLUGIN_SCRIPT_FILE) {
+			continue
+		}
+		if err := copyFile(filepath.Join(servedFolder, entry.Name()), filepath.Join(stagedFolder, entry.Name())); err != nil {
+			return err
+		}
+	}
+	scriptFile := lo.Ternary(rewritesPluginScripts(), PLUGIN_SCRIPT_FILE+ORIGINAL_FILE_SUFFIX, PLUGIN_SCRIPT_FILE)
+	if err := copyFile(pluginScriptPath(servedFolder), filepath.Join(stagedFolder, scriptFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// The upstream release files, main.js is read from main.js.orig when rewritten
+func pluginReleaseDigest(releaseFolder string) string {
+	return filesDigest(lo.Map(PLUGIN_RELEASE_FILES, func(releaseFile string, _ int) string {
+		if releaseFile == PLUGIN_SCRIPT_FILE {
+			return pluginScriptPath(releaseFolder)
+		}
+		return filepath.Join(releaseFolder, releaseFile)
+	}))
+}
+
+func publishPluginRelease(repo Repo, repoFolder string, version string, stagedFolder string) error {
+	releaseFolder := pluginReleaseFolder(repoFolder, version)
+	if err := os.MkdirAll(filepath.Dir(releaseFolder), 0755); err != nil {
+		return err
+	}
+	// A republished version replaces the served files
+	if err := os.RemoveAll(releaseFolder); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(stagedFolder, SCAN_RESULT_FILE))
+	if err := os.Rename(stagedFolder, releaseFolder); err != nil {
+		return err
+	}
+	removeQuarantined(stagedFolder)
+
+	for _, releaseFile := range PLUGIN_RELEASE_FILES {
+		filePath := filepath.Join(releaseFolder, releaseFile)
+		if _, err := os.Stat(filePath + ORIGINAL_FILE_SUFFIX); err == nil {
+			provenance.recordFile(filePath+ORIGINAL_FILE_SUFFIX, pluginReleaseUrl(repo.Repo, version, releaseFile))
+			continue
+		}
+		provenance.recordFile(filePath, pluginReleaseUrl(repo.Repo, version, releaseFile))
+	}
+	provenance.recordFile(filepath.Join(releaseFolder, RELEASE_NOTES_FILE), pluginReleaseNotesUrl(repo.Repo, version))
+	return nil
+}
+
+// Releases are always staged, the staged files are dropped when they match the served version
+func scanPluginRelease(repo Repo, repoFolder string, version string, releaseFolder string, previousManifest []byte) error {
+	servedFolder := pluginReleaseFolder(repoFolder, version)
+	if _, err := os.Stat(servedFolder); err == nil && pluginReleaseDigest(servedFolder) == pluginReleaseDigest(releaseFolder) {
+		removeQuarantined(releaseFolder)
+		releaseFolder = servedFolder
+	}
+
+	findings, err := scanScriptFile(pluginScriptPath(releaseFolder), scanRules)
+	if os.IsNotExist(err) {
+		findings = append(findings, ScanFinding{Rule: SCAN_MISSING_SCRIPT, Severity: "high", Description: "The release has no main.js to scan"})
+	} else if err != nil {
+		return err
+	}
+
+	var previous struct {
+		Version string
+	}
+	json.Unmarshal(previousManifest, &previous)
+	staged := releaseFolder != servedFolder
+	blocking := blockingFindings(findings)
+	var capabilityDiff *CapabilityDiff
+	if previous.Version != "" && (previous.Version != version || staged) {
+		diff, err := diffScriptFiles(previous.Version, pluginScriptPath(pluginReleaseFolder(repoFolder, previous.Version)), version, pluginScriptPath(releaseFolder))
+		if err == nil {
+			capabilityDiff = &diff
+		}
+	}
+	held := len(blocking) > 0
+	reviewStatus := ""
+	if staged && config.Review.Enabled {
+		reviewStatus, err = queueReview(Review{
+			Repo:            repo.Repo,
+			Kind:            "plugin",
+			Version:         version,
+			PreviousVersion: previous.Version,
+			Findings:        findings,
+			CapabilityDiff:  capabilityDiff,
+			Digest:          pluginReleaseDigest(releaseFolder),
+		})
+		if err != nil {
+			return err
+		}
+		held = reviewStatus != REVIEW_APPROVED
+	}
+	syncReport.update(repo.Repo, "plugin", func(report *RepoReport) {
+		report.Version = version
+		if previous.Version != version {
+			report.PreviousVersion = previous.Version
+		}
+		report.Findings = findings
+		report.CapabilityDiff = capabilityDiff
+		report.Quarantined = staged && held
+		report.Review = reviewStatus
+	})
+
+	if !staged {
+		if len(blocking) > 0 {
+			log.Printf("[!] Published release %s %s matches scan rules: %s", repo.Repo, version, describeFindings(blocking))
+		}
+		return nil
+	}
+	if !held {
+		return publishPluginRelease(repo, repoFolder, version, releaseFolder)
+	}
+	if reviewStatus == REVIEW_REJECTED {
+		removeQuarantined(releaseFolder)
+		return restoreManifest(repo, repoFolder, previousManifest)
+	}
+
+	if len(blocking) > 0 {
+		log.Printf("[!] Holding %s %s in quarantine: %s", repo.Repo, version, describeFindings(blocking))
+	}
+	data, err := json.MarshalIndent(ScanResult{Repo: repo.Repo, Version: version, Findings: findings}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(filepath.Join(releaseFolder, SCAN_RESULT_FILE), data, 0644); err != nil {
+		return err
+	}
+	return restoreManifest(repo, repoFolder, previousManifest)
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+const (
+	SEARCH_INDEX_FILE = "search-index.json"
+	// Only the start of a README is indexed, the rest is rarely more than changelogs
+	SEARCH_README_LIMIT = 64 * 1024
+	CHANGES_RETENTION   = 90 * 24 * time.Hour
+	SEARCH_LIMIT        = 50
+)
+
+type IndexedFile struct {
+	Path   string `json:"path"`
+	Sha256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+type IndexedVersion struct {
+	Version      string        `json:"version"`
+	ReleaseNotes string        `json:"releaseNotes,omitempty"`
+	Files        []IndexedFile `json:"files"`
+}
+
+type IndexedItem struct {
+	Kind        string           `json:"kind"`
+	Id          string           `json:"id"`
+	Name        string           `json:"name"`
+	Author      string           `json:"author"`
+	Description string           `json:"description,omitempty"`
+	Repo        string           `json:"repo"`
+	Version     string           `json:"version"`
+	Downloads   int              `json:"downloads"`
+	Offline     string           `json:"offline,omitempty"`
+	Versions    []IndexedVersion `json:"versions,omitempty"`
+	Readme      string           `json:"readme,omitempty"`
+}
+
+type IndexedChange struct {
+	Kind string    `json:"kind"`
+	Id   string    `json:"id"`
+	Name string    `json:"name"`
+	Repo string    `json:"repo"`
+	From string    `json:"from,omitempty"`
+	To   string    `json:"to,omitempty"`
+	At   time.Time `json:"at"`
+}
+
+type SearchIndex struct {
+	GeneratedAt time.Time       `json:"generatedAt"`
+	Commit      string          `json:"commit,omitempty"`
+	Items       []IndexedItem   `json:"items"`
+	Changes     []IndexedChange `json:"changes"`
+}
+
+type SearchResult struct {
+	IndexedItem
+	Score   int      `json:"score"`
+	Matches []string `json:"matches"`
+}
+
+func (item IndexedItem) key() string {
+	return item.Kind + "/" + item.Id
+}
+
+func (change IndexedChange) key() string {
+	return change.Kind + "/" + change.Id
+}
+
+// Hashes come from the provenance records, so the index can be built before SHA256SUMS
+type fileDigests struct {
+	downloadFolder string
+	records        map[string]map[string]Provenance
+}
+
+func (digests *fileDigests) sha256(filePath string) string {
+	folder, name := provenanceFolder(digests.downloadFolder, filePath)
+	if _, ok := digests.records[folder]; !ok {
+		digests.records[folder] = readProvenance(folder)
+	}
+	if record, ok := digests.records[folder][name]; ok && record.Sha256 != "" {
+		return record.Sha256
+	}
+	sha, _ := fileSha256(filePath)
+	return sha
+}
+
+func indexedFiles(downloadFolder string, folder string, names []string, digests *fileDigests) []IndexedFile {
+	var files []IndexedFile
+	for _, name := range names {
+		filePath := filepath.Join(folder, name)
+		info, err := os.Stat(filePath)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		relativePath, _ := filepath.Rel(downloadFolder, filePath)
+		files = append(files, IndexedFile{Path: filepath.ToSlash(relativePath), Sha256: digests.sha256(filePath), Size: info.Size()})
+	}
+	return files
+}
+
+func indexedPluginVersions(downloadFolder string, repoFolder string, digests *fileDigests) []IndexedVersion {
+	entries, err := os.ReadDir(filepath.Join(repoFolder, "releases", "download"))
+	if err != nil {
+		return nil
+	}
+	var versions []IndexedVersion
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		releaseFolder := pluginReleaseFolder(repoFolder, entry.Name())
+		versions = append(versions, IndexedVersion{
+			Version:      entry.Name(),
+			ReleaseNotes: readReleaseNotes(releaseFolder),
+			Files:        indexedFiles(downloadFolder, releaseFolder, append(append([]string{}, PLUGIN_RELEASE_FILES...), RELEASE_NOTES_FILE), digests),
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool { return compareVersions(versions[i].Version, versions[j].Version) > 0 })
+	return versions
+}
+
+func indexedReadme(repoFolder string) string {
+	readme, _ := os.ReadFile(filepath.Join(repoFolder, README_FILE))
+	if len(readme) > SEARCH_README_LIMIT {
+		readme = readme[:SEARCH_README_LIMIT]
+	}
+	return strings.ToValidUTF8(string(readme), "")
+}
+
+// Changes are found by comparing versions with the previous index
+func indexChanges(previous SearchIndex, items []IndexedItem, now time.Time) []IndexedChange {
+	changes := lo.Filter(previous.Changes, func(change IndexedChange, _ int) bool {
+		return now.Sub(change.At) < CHANGES_RETENTION
+	})
+	if len(previous.Items) == 0 {
+		return changes
+	}
+
+	previousItems := lo.KeyBy(previous.Items, IndexedItem.key)
+	var current []IndexedChange
+	for _, item := range items {
+		before, ok := previousItems[item.key()]
+		if ok && before.Version == item.Version {
+			continue
+		}
+		current = append(current, IndexedChange{Kind: item.Kind, Id: item.Id, Name: item.Name, Repo: item.Repo, From: before.Version, To: item.Version, At: now})
+	}
+	currentItems := lo.KeyBy(items, IndexedItem.key)
+	for _, item := range previous.Items {
+		if _, ok := currentItems[item.key()]; !ok {
+			current = append(current, IndexedChange{Kind: item.Kind, Id: item.Id, Name: item.Name, Repo: item.Repo, From: item.Version, At: now})
+		}
+	}
+	sort.Slice(current, func(i, j int) bool { return current[i].key() < current[j].key() })
+	return append(current, changes...)
+}
+
+func buildSearchIndex(downloadFolder string, previous SearchIndex) (SearchIndex, error) {
+	index := SearchIndex{GeneratedAt: time.Now().UTC(), Commit: lo.Ternary(provenance.commit != "", provenance.commit, previous.Commit), Items: []IndexedItem{}}
+	plugins, err := readCommunityPlugins(downloadFolder)
+	if err != nil {
+		return index, err
+	}
+	themes, err := readCommunityThemes(downloadFolder)
+	if err != nil {
+		return index, err
+	}
+	digests := &fileDigests{downloadFolder: downloadFolder, records: map[string]map[string]Provenance{}}
+
+	pluginDownloads := readPluginDownloads(downloadFolder)
+	for _, plugin := range plugins {
+		repoFolder := filepath.Join(downloadFolder, plugin.Repo)
+		manifest, err := readManifest(repoFolder)
+		if err != nil {
+			continue
+		}
+		item := IndexedItem{
+			Kind:        "plugin",
+			Id:          plugin.Id,
+			Name:        plugin.Name,
+			Author:      plugin.Author,
+			Description: plugin.Description,
+			Repo:        plugin.Repo,
+			Version:     manifest.Version,
+			Downloads:   pluginDownloads[plugin.Id],
+			Versions:    indexedPluginVersions(downloadFolder, repoFolder, digests),
+			Readme:      indexedReadme(repoFolder),
+		}
+		if classification, err := classifyPluginOffline(repoFolder, plugin.Repo); err == nil {
+			item.Offline = classification.Class
+		}
+		index.Items = append(index.Items, item)
+	}
+
+	themeDownloads := readThemeDownloads(downloadFolder)
+	for _, theme := range themes {
+		repoFolder := filepath.Join(downloadFolder, theme.Repo)
+		manifest, err := readManifest(repoFolder)
+		if err != nil {
+			continue
+		}
+		index.Items = append(index.Items, IndexedItem{
+			Kind:      "theme",
+			Id:        theme.Name,
+			Name:      theme.Name,
+			Author:    theme.Author,
+			Repo:      theme.Repo,
+			Version:   manifest.Version,
+			Downloads: themeDownloads[theme.Name],
+			Versions: []IndexedVersion{{
+				Version: manifest.Version,
+				Files:   indexedFiles(downloadFolder, repoFolder, append(append([]string{}, THEMES_FILES...), theme.Screenshot), digests),
+			}},
+			Readme: indexedReadme(repoFolder),
+		})
+	}
+
+	index.Changes = indexChanges(previous, index.Items, index.GeneratedAt)
+	return index, nil
+}
+
+func readSearchIndex(indexPath string) (SearchIndex, error) {
+	var index SearchIndex
+	err := readJsonFile(indexPath, &index)
+	return index, err
+}
+
+func writeSearchIndex(downloadFolder string, indexPath string) (SearchIndex, error) {
+	previous, _ := readSearchIndex(indexPath)
+	index, err := buildSearchIndex(downloadFolder, previous)
+	if err != nil {
+		return index, err
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return index, err
+	}
+	if err := os.WriteFile(indexPath+".tmp", data, 0644); err != nil {
+		return index, err
+	}
+	return index, os.Rename(indexPath+".tmp", indexPath)
+}
+
+// The server keeps the index in memory and reloads it when a sync rewrites it
+type searchIndexCache struct {
+	sync.Mutex
+	path    string
+	modTime time.Time
+	index   SearchIndex
+	lowered [][]string
+}
+
+var (
+	SEARCH_FIELDS       = []string{"name", "id", "author", "description", "readme"}
+	SEARCH_FIELD_SCORES = []int{10, 10, 3, 5, 1}
+)
+
+func (cache *searchIndexCache) get() (SearchIndex, [][]string, error) {
+	cache.Lock()
+	defer cache.Unlock()
+	info, err := os.Stat(cache.path)
+	if err != nil {
+		return cache.index, cache.lowered, err
+	}
+	if info.ModTime().Equal(cache.modTime) {
+		return cache.index, cache.lowered, nil
+	}
+
+	index, err := readSearchIndex(cache.path)
+	if err != nil {
+		return cache.index, cache.lowered, err
+	}
+	cache.index, cache.modTime = index, info.ModTime()
+	cache.lowered = lo.Map(index.Items, func(item IndexedItem, _ int) []string {
+		return lo.Map([]string{item.Name, item.Id, item.Author, item.Description, item.Readme}, func(field string, _ int) string {
+			return strings.ToLower(field)
+		})
+	})
+	return cache.index, cache.lowered, nil
+}
+
+// Every term has to be found in one of the fields, matches in the name count
+// more than matches in the README
+func searchItems(index SearchIndex, lowered [][]string, query string, kind string) []SearchResult {
+	terms := strings.Fields(strings.ToLower(query))
+	results := []SearchResult{}
+	for i, item := range index.Items {
+		if kind != "" && item.Kind != kind {
+			continue
+		}
+		score := 0
+		matches := map[string]bool{}
+		for _, term := range terms {
+			termScore := 0
+			for j, field := range lowered[i] {
+				if strings.Contains(field, term) {
+					termScore += SEARCH_FIELD_SCORES[j]
+					matches[SEARCH_FIELDS[j]] = true
+				}
+			}
+			if termScore == 0 {
+				score = 0
+				break
+			}
+			score += termScore
+		}
+		if score == 0 && len(terms) > 0 {
+			continue
+		}
+
+		item.Readme, item.Versions = "", nil
+		results = append(results, SearchResult{
+			IndexedItem: item,
+			Score:       score,
+			Matches:     lo.Filter(SEARCH_FIELDS, func(field string, _ int) bool { return matches[field] }),
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Downloads > results[j].Downloads
+	})
+	return results
+}
+
+func writeJsonResponse(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+func (server *mirrorServer) searchIndex(w http.ResponseWriter, r *http.Request) (SearchIndex, [][]string, bool) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return SearchIndex{}, nil, false
+	}
+	index, lowered, err := server.index.get()
+	if err != nil {
+		http.Error(w, "search index is not available, run sync first", http.StatusServiceUnavailable)
+		return index, lowered, false
+	}
+	return index, lowered, true
+}
+
+// GET /api/search?q=kanban&kind=plugin&limit=20
+func (server *mirrorServer) serveSearch(w http.ResponseWriter, r *http.Request) {
+	index, lowered, ok := server.searchIndex(w, r)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = SEARCH_LIMIT
+	}
+	results := searchItems(index, lowered, r.URL.Query().Get("q"), r.URL.Query().Get("kind"))
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	writeJsonResponse(w, results)
+}
+
+// GET /api/plugins/<id> and /api/themes/<name>
+func (server *mirrorServer) serveItem(w http.ResponseWriter, r *http.Request) {
+	index, _, ok := server.searchIndex(w, r)
+	if !ok {
+		return
+	}
+	kinds, id, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+	item, found := lo.Find(index.Items, func(item IndexedItem) bool {
+		return item.Kind+"s" == kinds && item.Id == id
+	})
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	item.Readme = ""
+	writeJsonResponse(w, item)
+}
+
+// GET /api/changes?since=2024-01-31&kind=theme, the last week by default
+func (server *mirrorServer) serveChanges(w http.ResponseWriter, r *http.Request) {
+	index, _, ok := server.searchIndex(w, r)
+	if !ok {
+		return
+	}
+	since := time.Now().Add(-7 * 24 * time.Hour)
+	if value := r.URL.Query().Get("since"); value != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, value); err != nil {
+			if since, err = time.Parse("2006-01-02", value); err != nil {
+				http.Error(w, "since must be a date (2006-01-02) or an RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	kind := r.URL.Query().Get("kind")
+	writeJsonResponse(w, lo.Filter(index.Changes, func(change IndexedChange, _ int) bool {
+		return !change.At.Before(since) && (kind == "" || change.Kind == kind)
+	}))
+}
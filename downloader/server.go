@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/samber/lo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var BRANCH_PATH_REGEX = regexp.MustCompile(`^(/[^/]+/[^/]+)/(?:HEAD|master|main)/(.*)$`)
+
+type mirrorServer struct {
+	downloadFolder string
+	rolloutPath    string
+	files          http.Handler
+	index          *searchIndexCache
+}
+
+// X-Forwarded-For is only taken from a proxy in server.trustedProxies and read
+// from the right, the client is the first address that isn't a trusted proxy
+func (server *mirrorServer) clientIdentity(r *http.Request) (net.IP, string) {
+	remoteAddr := r.RemoteAddr
+	if config.Server.TrustForwardedFor && trustedProxy(r.RemoteAddr) {
+		forwardedFor := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		for i := len(forwardedFor) - 1; i >= 0; i-- {
+			forwarded := strings.TrimSpace(forwardedFor[i])
+			if forwarded == "" {
+				break
+			}
+			remoteAddr = forwarded
+			if !trustedProxy(forwarded) {
+				break
+			}
+		}
+	}
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		remoteAddr = host
+	}
+
+	return net.ParseIP(remoteAddr), authenticatedIdentity(r)
+}
+
+func trustedProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	return lo.SomeBy(config.Server.TrustedProxies, func(proxy string) bool {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			return network.Contains(ip)
+		}
+		return ip.Equal(net.ParseIP(proxy))
+	})
+}
+
+// The identity header is only taken from a proxy in server.trustedProxies,
+// basic auth needs the password of one of server.users (bcrypt hashes)
+func authenticatedIdentity(r *http.Request) string {
+	if config.Server.IdentityHeader != "" && trustedProxy(r.RemoteAddr) {
+		if identity := r.Header.Get(config.Server.IdentityHeader); identity != "" {
+			return identity
+		}
+	}
+	user, password, ok := r.BasicAuth()
+	hash, known := config.Server.Users[user]
+	if !ok || !known || bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return ""
+	}
+	return user
+}
+
+func (server *mirrorServer) serveDesktopReleases(w http.ResponseWriter, r *http.Request) {
+	upstream, err := readDesktopReleases(server.downloadFolder, DESKTOP_RELEASES_UPSTREAM_FILE)
+	if err != nil {
+		server.files.ServeHTTP(w, r)
+		return
+	}
+
+	rollout, err := loadRollout(server.rolloutPath)
+	if err != nil {
+		log.Printf("%v\n\n", err)
+		http.Error(w, "rollout is not readable", http.StatusInternalServerError)
+		return
+	}
+
+	clientIp, identity := server.clientIdentity(r)
+	group, channel := rollout.versionFor(clientIp, identity)
+	served, err := buildServedDesktopReleases(server.downloadFolder, upstream, channel)
+	if err != nil {
+		log.Printf("[!] Group %s points at an unavailable release, serving latest: %s", group, err)
+		if served, err = buildServedDesktopReleases(server.downloadFolder, upstream, "latest"); err != nil {
+			http.Error(w, "no desktop release is mirrored", http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Rollout-Group", group)
+	json.NewEncoder(w).Encode(served)
+}
+
+func (server *mirrorServer) serveReviews(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		reviews, err := loadReviews()
+		if err != nil {
+			http.Error(w, "reviews are not readable", http.StatusInternalServerError)
+			return
+		}
+		status := r.URL.Query().Get("status")
+		if status == "" {
+			status = REVIEW_PENDING
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(lo.Filter(reviews, func(review *Review, _ int) bool { return status == "all" || review.Status == status }))
+	case http.MethodPost:
+		identity := authenticatedIdentity(r)
+		if identity == "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="reviews"`)
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		if !lo.Contains(config.Review.Reviewers, identity) {
+			http.Error(w, "not a reviewer", http.StatusForbidden)
+			return
+		}
+
+		var decision struct {
+			Repo     string `json:"repo"`
+			Version  string `json:"version"`
+			Decision string `json:"decision"`
+			Comment  string `json:"comment"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&decision); err != nil || (decision.Decision != "approve" && decision.Decision != "reject") {
+			http.Error(w, "expected {repo, version, decision: approve|reject, comment}", http.StatusBadRequest)
+			return
+		}
+		review, err := decideReview(server.downloadFolder, decision.Repo, decision.Version, lo.Ternary(decision.Decision == "approve", REVIEW_APPROVED, REVIEW_REJECTED), identity, decision.Comment)
+		if review == nil && err != nil {
+			http.Error(w, strings.TrimPrefix(err.Error(), "[!] "), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("%v\n\n", err)
+		}
+		log.Printf("[*] %s %s %s by %s", review.Repo, review.Version, review.Status, identity)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(review)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Theme screenshots can be asked for at a smaller width with ?w=
+func (server *mirrorServer) thumbnailPath(filePath string, width int) (string, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(filePath, "/"), "/", 3)
+	if len(parts) < 3 {
+		return "", false
+	}
+	thumbnail, ok := screenshotThumbnail(filepath.Join(server.downloadFolder, parts[0], parts[1]), parts[2], width)
+	if !ok {
+		return "", false
+	}
+	return "/" + parts[0] + "/" + parts[1] + "/" + thumbnail.Path, true
+}
+
+func (server *mirrorServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	filePath := path.Clean("/" + strings.TrimPrefix(r.URL.Path, "/files"))
+	if match := BRANCH_PATH_REGEX.FindStringSubmatch(filePath); match != nil {
+		filePath = match[1] + "/" + match[2]
+	}
+	r.URL.Path = filePath
+
+	if filePath == "/"+OBSIDIAN_GITHUB_PATH+"/"+DESKTOP_RELEASES_FILE {
+		server.serveDesktopReleases(w, r)
+		return
+	}
+	if width, err := strconv.Atoi(r.URL.Query().Get("w")); err == nil {
+		if thumbnailPath, ok := server.thumbnailPath(filePath, width); ok {
+			r.URL.Path = thumbnailPath
+		}
+	}
+	if strings.HasPrefix(filePath, "/stats/") {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+	// Fonts of mirrored theme and cdn assets are loaded cross-origin by the app
+	if strings.Contains(filePath, "/"+THEME_ASSETS_FOLDER+"/") || strings.HasPrefix(filePath, "/cdn/") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	server.files.ServeHTTP(w, r)
+}
+
+func serveCommand(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	rolloutPath := flags.String("rollout", ROLLOUT_FILENAME, "Rollout file path")
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+
+	server := &mirrorServer{
+		downloadFolder: DOWNLOAD_FOLDER,
+		rolloutPath:    *rolloutPath,
+		files:          http.FileServer(http.Dir(DOWNLOAD_FOLDER)),
+		index:          &searchIndexCache{path: SEARCH_INDEX_FILE},
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/files/", server)
+	mux.HandleFunc("/api/reviews", server.serveReviews)
+	mux.HandleFunc("/api/search", server.serveSearch)
+	mux.HandleFunc("/api/plugins/", server.serveItem)
+	mux.HandleFunc("/api/themes/", server.serveItem)
+	mux.HandleFunc("/api/changes", server.serveChanges)
+	mux.Handle("/catalog/", http.StripPrefix("/catalog/", http.FileServer(http.Dir(CATALOG_FOLDER))))
+	mux.Handle("/", http.FileServer(http.Dir(filepath.Clean(config.Server.WebFolder))))
+
+	log.Printf("[*] Serving %s on %s", DOWNLOAD_FOLDER, config.Server.Listen)
+	return http.ListenAndServe(config.Server.Listen, mux)
+}
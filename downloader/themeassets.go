@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+const (
+	THEME_ASSETS_FOLDER = "assets"
+	THEME_IMPORT_DEPTH  = 3
+)
+
+var (
+	THEME_CSS_FILES = []string{"theme.css", "obsidian.css"}
+	CSS_URL_REGEX   = regexp.MustCompile(`(@import\s+|url\(\s*)(["']?)((?:https?:)?//[^"')\s;]+)`)
+)
+
+func mirrorsThemeAssets() bool {
+	return config.Themes.MirrorAssets && config.Patch.ServerAddress != ""
+}
+
+func downloadThemeAsset(repoFolder string, assetUrl *url.URL) (string, bool, error) {
+	resp, body, err := fetchAsset(assetUrl)
+	if err != nil {
+		return "", false, err
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	isCss := strings.HasPrefix(contentType, "text/css")
+	assetPath := mirroredAssetPath(filepath.Join(repoFolder, THEME_ASSETS_FOLDER), assetUrl, contentType)
+	localPath := lo.Ternary(isCss, assetPath+ORIGINAL_FILE_SUFFIX, assetPath)
+	if err := writeAsset(localPath, assetUrl, resp, body); err != nil {
+		return "", false, err
+	}
+	return assetPath, isCss, nil
+}
+
+// Downloads the assets a stylesheet references and points them at the mirror,
+// imported stylesheets are rewritten the same way
+func rewriteThemeCss(repo Repo, repoFolder string, css string, baseUrl *url.URL, depth int) string {
+	mirrored := map[string]string{}
+	return CSS_URL_REGEX.ReplaceAllStringFunc(css, func(match string) string {
+		groups := CSS_URL_REGEX.FindStringSubmatch(match)
+		prefix, quote, reference := groups[1], groups[2], groups[3]
+		if mirrorUrl, ok := mirrored[reference]; ok {
+			return prefix + quote + mirrorUrl
+		}
+
+		assetUrl, err := baseUrl.Parse(reference)
+		if err != nil {
+			return match
+		}
+		assetPath, isCss, err := downloadThemeAsset(repoFolder, assetUrl)
+		if err != nil {
+			log.Printf("%v\n\n", err)
+			return match
+		}
+		if isCss {
+			imported, err := os.ReadFile(assetPath + ORIGINAL_FILE_SUFFIX)
+			if err == nil && depth < THEME_IMPORT_DEPTH {
+				imported = []byte(rewriteThemeCss(repo, repoFolder, string(imported), assetUrl, depth+1))
+			}
+			if err := replaceFile(assetPath, imported, 0644); err != nil {
+				log.Printf("%v\n\n", err)
+				return match
+			}
+			provenance.recordDerived(assetPath, assetPath+ORIGINAL_FILE_SUFFIX)
+		}
+
+		relativePath, _ := filepath.Rel(repoFolder, assetPath)
+		mirrorUrl := fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(config.Patch.ServerAddress, "/"), repo.Repo, filepath.ToSlash(relativePath))
+		mirrored[reference] = mirrorUrl
+		return prefix + quote + mirrorUrl
+	})
+}
+
+func mirrorThemeAssets(repo Repo, repoFolder string) error {
+	if !mirrorsThemeAssets() {
+		return nil
+	}
+	for _, file := range THEME_CSS_FILES {
+		cssPath := filepath.Join(repoFolder, file)
+		origInfo, err := os.Stat(cssPath + ORIGINAL_FILE_SUFFIX)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if isRewritten(cssPath, origInfo, config.Patch.ServerAddress) {
+			continue
+		}
+		css, err := os.ReadFile(cssPath + ORIGINAL_FILE_SUFFIX)
+		if err != nil {
+			return err
+		}
+
+		baseUrl, _ := url.Parse(fmt.Sprintf("https://raw.githubusercontent.com/%s/HEAD/%s", repo.Repo, file))
+		if err := replaceFile(cssPath, []byte(rewriteThemeCss(repo, repoFolder, string(css), baseUrl, 0)), 0644); err != nil {
+			return err
+		}
+		provenance.recordRewritten(cssPath, cssPath+ORIGINAL_FILE_SUFFIX, config.Patch.ServerAddress)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+const (
+	THUMBNAILS_FOLDER      = "thumbnails"
+	THUMBNAILS_INFO_FILE   = "thumbnails.json"
+	THUMBNAIL_JPEG_QUALITY = 85
+	// Screenshots larger than this are not decoded
+	MAX_SCREENSHOT_PIXELS = 50_000_000
+)
+
+type Thumbnail struct {
+	Path   string `json:"path"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Size   int64  `json:"size"`
+}
+
+type ScreenshotInfo struct {
+	Screenshot string      `json:"screenshot"`
+	Width      int         `json:"width"`
+	Height     int         `json:"height"`
+	Thumbnails []Thumbnail `json:"thumbnails"`
+}
+
+func readThumbnailsInfo(repoFolder string) []ScreenshotInfo {
+	var screenshots []ScreenshotInfo
+	readJsonFile(filepath.Join(repoFolder, THUMBNAILS_INFO_FILE), &screenshots)
+	return screenshots
+}
+
+// The smallest thumbnail at least as wide as asked for, the screenshot itself
+// is the better choice when there is none
+func screenshotThumbnail(repoFolder string, screenshot string, width int) (Thumbnail, bool) {
+	for _, info := range readThumbnailsInfo(repoFolder) {
+		if info.Screenshot != screenshot {
+			continue
+		}
+		for _, thumbnail := range info.Thumbnails {
+			if thumbnail.Width >= width {
+				return thumbnail, true
+			}
+		}
+	}
+	return Thumbnail{}, false
+}
+
+func isOpaque(img image.Image) bool {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return opaque.Opaque()
+	}
+	return false
+}
+
+// Box filter, every pixel of the thumbnail averages the screenshot pixels it covers
+func resizeImage(src image.Image, width int) *image.RGBA {
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	height := srcHeight * width / srcWidth
+	if height < 1 {
+		height = 1
+	}
+
+	source := image.NewRGBA(image.Rect(0, 0, srcWidth, srcHeight))
+	draw.Draw(source, source.Bounds(), src, bounds.Min, draw.Src)
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*srcHeight/height, (y+1)*srcHeight/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := x*srcWidth/width, (x+1)*srcWidth/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := source.Pix[sy*source.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+			count := (y1 - y0) * (x1 - x0)
+			offset := y*resized.Stride + x*4
+			for c := 0; c < 4; c++ {
+				resized.Pix[offset+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+	return resized
+}
+
+func thumbnailWidths() []int {
+	widths := lo.Uniq(config.Themes.ThumbnailWidths)
+	sort.Ints(widths)
+	return widths
+}
+
+func writeThumbnail(repoFolder string, screenshot string, img image.Image, width int) (Thumbnail, error) {
+	resized := resizeImage(img, width)
+	opaque := isOpaque(img)
+	var data bytes.Buffer
+	var err error
+	if opaque {
+		err = jpeg.Encode(&data, resized, &jpeg.Options{Quality: THUMBNAIL_JPEG_QUALITY})
+	} else {
+		err = png.Encode(&data, resized)
+	}
+	if err != nil {
+		return Thumbnail{}, err
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+screenshot), "/")
+	name = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, path.Ext(name)), width, lo.Ternary(opaque, ".jpg", ".png"))
+	thumbnailPath := filepath.Join(repoFolder, THUMBNAILS_FOLDER, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(thumbnailPath), 0755); err != nil {
+		return Thumbnail{}, err
+	}
+	if err := replaceFile(thumbnailPath, data.Bytes(), 0644); err != nil {
+		return Thumbnail{}, err
+	}
+	provenance.recordDerived(thumbnailPath, filepath.Join(repoFolder, screenshot))
+	return Thumbnail{
+		Path:   THUMBNAILS_FOLDER + "/" + name,
+		Width:  resized.Bounds().Dx(),
+		Height: resized.Bounds().Dy(),
+		Size:   int64(data.Len()),
+	}, nil
+}
+
+func screenshotThumbnails(repoFolder string, screenshot string) (ScreenshotInfo, error) {
+	info := ScreenshotInfo{Screenshot: screenshot}
+	file, err := os.Open(filepath.Join(repoFolder, screenshot))
+	if err != nil {
+		return info, err
+	}
+	defer file.Close()
+	imageConfig, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return info, err
+	}
+	if imageConfig.Width*imageConfig.Height > MAX_SCREENSHOT_PIXELS {
+		return info, fmt.Errorf("screenshot is too large: %dx%d", imageConfig.Width, imageConfig.Height)
+	}
+	info.Width, info.Height = imageConfig.Width, imageConfig.Height
+
+	if _, err := file.Seek(0, 0); err != nil {
+		return info, err
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return info, err
+	}
+	for _, width := range thumbnailWidths() {
+		if width <= 0 || width >= info.Width {
+			continue
+		}
+		thumbnail, err := writeThumbnail(repoFolder, screenshot, img, width)
+		if err != nil {
+			return info, err
+		}
+		info.Thumbnails = append(info.Thumbnails, thumbnail)
+	}
+	return info, nil
+}
+
+// Thumbnails are regenerated when a screenshot changes, or when the configured
+// widths don't match the ones recorded
+func thumbnailsUpToDate(repoFolder string, screenshots []string) bool {
+	infoStat, err := os.Stat(filepath.Join(repoFolder, THUMBNAILS_INFO_FILE))
+	if err != nil {
+		return false
+	}
+	recorded := readThumbnailsInfo(repoFolder)
+	if len(recorded) != len(screenshots) {
+		return false
+	}
+	for i, info := range recorded {
+		screenshotStat, err := os.Stat(filepath.Join(repoFolder, screenshots[i]))
+		if info.Screenshot != screenshots[i] || err != nil || screenshotStat.ModTime().After(infoStat.ModTime()) {
+			return false
+		}
+		var widths []int
+		for _, width := range thumbnailWidths() {
+			if width > 0 && width < info.Width {
+				widths = append(widths, width)
+			}
+		}
+		if len(widths) != len(info.Thumbnails) {
+			return false
+		}
+		for j, thumbnail := range info.Thumbnails {
+			if thumbnail.Width != widths[j] {
+				return false
+			}
+			if _, err := os.Stat(filepath.Join(repoFolder, filepath.FromSlash(thumbnail.Path))); err != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func writeThemeThumbnails(repoFolder string, screenshots []string) error {
+	screenshots = filterExisting(repoFolder, screenshots)
+	if len(config.Themes.ThumbnailWidths) == 0 || len(screenshots) == 0 || thumbnailsUpToDate(repoFolder, screenshots) {
+		return nil
+	}
+	if err := os.RemoveAll(filepath.Join(repoFolder, THUMBNAILS_FOLDER)); err != nil {
+		return err
+	}
+
+	infos := []ScreenshotInfo{}
+	for _, screenshot := range screenshots {
+		info, err := screenshotThumbnails(repoFolder, screenshot)
+		if err != nil {
+			log.Printf("[!] Error generating thumbnails: %s, %s\n\n", filepath.Join(repoFolder, screenshot), err)
+		}
+		infos = append(infos, info)
+	}
+	data, err := json.MarshalIndent(infos, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(filepath.Join(repoFolder, THUMBNAILS_INFO_FILE), data, 0644)
+}
+
+func filterExisting(repoFolder string, files []string) []string {
+	var existing []string
+	for _, file := range files {
+		if info, err := os.Stat(filepath.Join(repoFolder, file)); file != "" && err == nil && !info.IsDir() {
+			existing = append(existing, file)
+		}
+	}
+	return existing
+}
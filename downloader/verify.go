@@ -0,0 +1,204 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/samber/lo"
+)
+
+func verifyCommand(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+
+	sums, files, err := readMirrorIndex(DOWNLOAD_FOLDER)
+	if err != nil {
+		return fmt.Errorf("[!] Error reading mirror index, run sync first: %s", err)
+	}
+
+	failed, blobsFailed := 0, 0
+	digests := blobDigests{}
+	if config.Blobs.Enabled {
+		blobsFailed = verifyBlobs(config.Blobs.Folder, sums, files, digests)
+	}
+	for _, file := range files {
+		sha, err := digests.sha256(filepath.Join(DOWNLOAD_FOLDER, filepath.FromSlash(file)), sums[file])
+		switch {
+		case err != nil:
+			fmt.Printf("%s: MISSING\n", file)
+			failed++
+		case sha != sums[file]:
+			fmt.Printf("%s: FAILED\n", file)
+			failed++
+		}
+	}
+
+	mirrored, err := mirrorFiles(DOWNLOAD_FOLDER)
+	if err != nil {
+		return err
+	}
+	for _, file := range mirrored {
+		if _, ok := sums[file]; !ok {
+			fmt.Printf("%s: NOT INDEXED\n", file)
+		}
+	}
+
+	if failed > 0 || blobsFailed > 0 {
+		return fmt.Errorf("[!] %d of %d files and %d blobs failed verification", failed, len(files), blobsFailed)
+	}
+	log.Printf("[*] Verified %d files", len(files))
+	return nil
+}
+
+// Every blob is hashed against its name, which also fills digests so the
+// files linked to it aren't hashed again. Digests of indexed files without a
+// blob fail, blobs no indexed file uses are only reported
+func verifyBlobs(blobsFolder string, sums map[string]string, files []string, digests blobDigests) int {
+	failed := 0
+	stored := map[string]bool{}
+	blobs, _ := filepath.Glob(filepath.Join(blobsFolder, "sha256", "*", "*"))
+	for _, blob := range blobs {
+		digest := filepath.Base(blob)
+		stored[digest] = true
+		sha, err := fileSha256(blob)
+		switch {
+		case err != nil:
+			fmt.Printf("%s: UNREADABLE\n", filepath.ToSlash(blob))
+			failed++
+		case sha != digest || filepath.Base(filepath.Dir(blob)) != digest[:lo.Min([]int{2, len(digest)})]:
+			fmt.Printf("%s: FAILED\n", filepath.ToSlash(blob))
+			failed++
+		default:
+			digests[digest] = sha
+		}
+	}
+
+	referenced := map[string]bool{}
+	for _, file := range files {
+		digest := sums[file]
+		if !isBlobStored(file) || digest == "" || referenced[digest] {
+			continue
+		}
+		referenced[digest] = true
+		if !stored[digest] {
+			fmt.Printf("%s: MISSING BLOB for %s\n", filepath.ToSlash(blobPath(blobsFolder, digest)), file)
+			failed++
+		}
+	}
+	for _, blob := range blobs {
+		if !referenced[filepath.Base(blob)] {
+			fmt.Printf("%s: EXTRA BLOB\n", filepath.ToSlash(blob))
+		}
+	}
+	return failed
+}
+
+func addFileToTar(writer *tar.Writer, filePath string, name string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err := writer.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, file)
+	return err
+}
+
+type exportedBlob struct {
+	Name string
+	Info os.FileInfo
+}
+
+func addLinkToTar(writer *tar.Writer, info os.FileInfo, name string, target string) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Typeflag = tar.TypeLink
+	header.Name = name
+	header.Linkname = target
+	header.Size = 0
+	return writer.WriteHeader(header)
+}
+
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("o", "mirror.tar.gz", "Output archive")
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+
+	sums, files, err := readMirrorIndex(DOWNLOAD_FOLDER)
+	if err != nil {
+		return fmt.Errorf("[!] Error reading mirror index, run sync first: %s", err)
+	}
+
+	out, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	compressor := gzip.NewWriter(out)
+	writer := tar.NewWriter(compressor)
+
+	extraFiles := []string{MIRROR_INDEX_FILE}
+	provenanceFiles, _ := filepath.Glob(filepath.Join(DOWNLOAD_FOLDER, "*", "*", PROVENANCE_FILENAME))
+	provenanceFiles = append(provenanceFiles, filepath.Join(DOWNLOAD_FOLDER, PROVENANCE_FILENAME))
+	for _, provenanceFile := range provenanceFiles {
+		if relativePath, err := filepath.Rel(DOWNLOAD_FOLDER, provenanceFile); err == nil {
+			extraFiles = append(extraFiles, filepath.ToSlash(relativePath))
+		}
+	}
+
+	// Files sharing a blob are archived once, the others are hard links to it
+	blobs := map[string]exportedBlob{}
+	for _, file := range append(files, extraFiles...) {
+		filePath := filepath.Join(DOWNLOAD_FOLDER, filepath.FromSlash(file))
+		info, err := os.Stat(filePath)
+		if err != nil {
+			continue
+		}
+		name := filepath.ToSlash(filepath.Join("files", file))
+		if blob, ok := blobs[sums[file]]; ok && os.SameFile(info, blob.Info) {
+			if err := addLinkToTar(writer, info, name, blob.Name); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := addFileToTar(writer, filePath, name); err != nil {
+			return err
+		}
+		if digest, ok := sums[file]; ok {
+			blobs[digest] = exportedBlob{Name: name, Info: info}
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	if err := compressor.Close(); err != nil {
+		return err
+	}
+	log.Printf("[*] Exported %d files to %s", len(files), *output)
+	return nil
+}
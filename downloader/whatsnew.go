@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+const (
+	WHATS_NEW_FILE      = "whats-new.md"
+	WHATS_NEW_JSON_FILE = "whats-new.json"
+)
+
+type ChangelogEntry struct {
+	Id           string `json:"id"`
+	Name         string `json:"name"`
+	Author       string `json:"author,omitempty"`
+	Description  string `json:"description,omitempty"`
+	Repo         string `json:"repo"`
+	From         string `json:"from,omitempty"`
+	To           string `json:"to,omitempty"`
+	ReleaseNotes string `json:"releaseNotes,omitempty"`
+}
+
+type ChangelogSection struct {
+	Added   []ChangelogEntry `json:"added"`
+	Updated []ChangelogEntry `json:"updated"`
+	Removed []ChangelogEntry `json:"removed"`
+}
+
+type DesktopChange struct {
+	Version string `json:"version"`
+	IsBeta  bool   `json:"isBeta,omitempty"`
+}
+
+type Changelog struct {
+	Date            time.Time        `json:"date"`
+	Since           *time.Time       `json:"since,omitempty"`
+	Commit          string           `json:"commit,omitempty"`
+	DesktopReleases []DesktopChange  `json:"desktopReleases"`
+	Plugins         ChangelogSection `json:"plugins"`
+	Themes          ChangelogSection `json:"themes"`
+}
+
+func (section ChangelogSection) count() int {
+	return len(section.Added) + len(section.Updated) + len(section.Removed)
+}
+
+func (changelog Changelog) isEmpty() bool {
+	return len(changelog.DesktopReleases) == 0 && changelog.Plugins.count() == 0 && changelog.Themes.count() == 0
+}
+
+func mirroredDesktopVersions(downloadFolder string) []string {
+	return lo.Map(listMirroredDesktopReleases(downloadFolder), func(release mirroredDesktopRelease, _ int) string {
+		return release.LatestVersion
+	})
+}
+
+func readChangelog(downloadFolder string) (Changelog, error) {
+	var changelog Changelog
+	err := readJsonFile(filepath.Join(downloadFolder, WHATS_NEW_JSON_FILE), &changelog)
+	return changelog, err
+}
+
+// Covers the changes since the last changelog, so versions approved between
+// syncs are listed by the next one
+func buildChangelog(downloadFolder string, index SearchIndex, desktopBefore []string) Changelog {
+	changelog := Changelog{
+		Date:            index.GeneratedAt,
+		Commit:          index.Commit,
+		DesktopReleases: []DesktopChange{},
+		Plugins:         ChangelogSection{Added: []ChangelogEntry{}, Updated: []ChangelogEntry{}, Removed: []ChangelogEntry{}},
+		Themes:          ChangelogSection{Added: []ChangelogEntry{}, Updated: []ChangelogEntry{}, Removed: []ChangelogEntry{}},
+	}
+	since := index.GeneratedAt
+	if previous, err := readChangelog(downloadFolder); err == nil {
+		since = previous.Date
+		changelog.Since = &previous.Date
+	}
+
+	for _, release := range listMirroredDesktopReleases(downloadFolder) {
+		if !lo.Contains(desktopBefore, release.LatestVersion) {
+			changelog.DesktopReleases = append(changelog.DesktopReleases, DesktopChange{Version: release.LatestVersion, IsBeta: release.IsBeta})
+		}
+	}
+
+	// Changes are newest first, an item changed twice goes from the oldest version to the newest
+	var keys []string
+	merged := map[string]*IndexedChange{}
+	for _, change := range index.Changes {
+		if !change.At.After(since) && !change.At.Equal(index.GeneratedAt) {
+			continue
+		}
+		if existing, ok := merged[change.key()]; ok {
+			existing.From = change.From
+			continue
+		}
+		change := change
+		merged[change.key()] = &change
+		keys = append(keys, change.key())
+	}
+
+	items := lo.KeyBy(index.Items, IndexedItem.key)
+	sections := map[string]*ChangelogSection{"plugin": &changelog.Plugins, "theme": &changelog.Themes}
+	for _, key := range keys {
+		change, item := merged[key], items[key]
+		entry := ChangelogEntry{Id: change.Id, Name: change.Name, Author: item.Author, Description: item.Description, Repo: change.Repo, From: change.From, To: change.To}
+		if version, ok := lo.Find(item.Versions, func(version IndexedVersion) bool { return version.Version == change.To }); ok {
+			entry.ReleaseNotes = version.ReleaseNotes
+		}
+		section := sections[change.Kind]
+		switch {
+		case change.From == change.To:
+			continue
+		case change.From == "":
+			section.Added = append(section.Added, entry)
+		case change.To == "":
+			section.Removed = append(section.Removed, entry)
+		default:
+			section.Updated = append(section.Updated, entry)
+		}
+	}
+	return changelog
+}
+
+func changelogLink(kind string, entry ChangelogEntry) string {
+	if kind == "plugin" {
+		return fmt.Sprintf("[%s](obsidian://show-plugin?id=%s)", entry.Name, url.QueryEscape(entry.Id))
+	}
+	return fmt.Sprintf("[%s](obsidian://show-theme?name=%s)", entry.Name, url.QueryEscape(entry.Name))
+}
+
+func writeChangelogSection(out io.Writer, kind string, title string, section ChangelogSection) {
+	lists := []struct {
+		Title   string
+		Entries []ChangelogEntry
+	}{
+		{"New " + title, section.Added},
+		{"Updated " + title, section.Updated},
+		{"Removed " + title, section.Removed},
+	}
+	for _, list := range lists {
+		if len(list.Entries) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "\n## %s\n\n", list.Title)
+		for _, entry := range list.Entries {
+			switch {
+			case entry.From == "":
+				fmt.Fprintf(out, "- %s %s", changelogLink(kind, entry), entry.To)
+				if entry.Author != "" {
+					fmt.Fprintf(out, " by %s", entry.Author)
+				}
+				if entry.Description != "" {
+					fmt.Fprintf(out, ": %s", entry.Description)
+				}
+				fmt.Fprintln(out)
+			case entry.To == "":
+				fmt.Fprintf(out, "- %s (was %s)\n", entry.Name, entry.From)
+			default:
+				fmt.Fprintf(out, "- %s %s → %s\n", changelogLink(kind, entry), entry.From, entry.To)
+			}
+			writeReleaseNotesCallout(out, entry.ReleaseNotes)
+		}
+	}
+}
+
+// A folded callout under the list item, so long notes don't drown the list
+func writeReleaseNotesCallout(out io.Writer, notes string) {
+	if notes == "" {
+		return
+	}
+	fmt.Fprintln(out, "    > [!note]- Release notes")
+	for _, line := range strings.Split(notes, "\n") {
+		fmt.Fprintln(out, strings.TrimRight("    > "+line, " "))
+	}
+}
+
+// Frontmatter keeps the note queryable once it is dropped into a vault
+func writeChangelogMarkdown(out io.Writer, changelog Changelog) {
+	fmt.Fprintln(out, "---")
+	fmt.Fprintln(out, "title: What's new on the mirror")
+	fmt.Fprintf(out, "date: %s\n", changelog.Date.Format(time.RFC3339))
+	if changelog.Since != nil {
+		fmt.Fprintf(out, "since: %s\n", changelog.Since.Format(time.RFC3339))
+	}
+	if changelog.Commit != "" {
+		fmt.Fprintf(out, "commit: %s\n", changelog.Commit)
+	}
+	fmt.Fprintf(out, "desktop_releases: %d\n", len(changelog.DesktopReleases))
+	fmt.Fprintf(out, "plugins_added: %d\n", len(changelog.Plugins.Added))
+	fmt.Fprintf(out, "plugins_updated: %d\n", len(changelog.Plugins.Updated))
+	fmt.Fprintf(out, "plugins_removed: %d\n", len(changelog.Plugins.Removed))
+	fmt.Fprintf(out, "themes_added: %d\n", len(changelog.Themes.Added))
+	fmt.Fprintf(out, "themes_updated: %d\n", len(changelog.Themes.Updated))
+	fmt.Fprintf(out, "themes_removed: %d\n", len(changelog.Themes.Removed))
+	fmt.Fprintln(out, "tags:")
+	fmt.Fprintln(out, "  - obsidian-mirror")
+	fmt.Fprintln(out, "---")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "# What's new on the mirror")
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "Synced on %s.\n", changelog.Date.Format("2006-01-02 15:04 MST"))
+
+	if len(changelog.DesktopReleases) > 0 {
+		fmt.Fprint(out, "\n## Desktop releases\n\n")
+		for _, release := range changelog.DesktopReleases {
+			fmt.Fprintf(out, "- Obsidian %s%s\n", release.Version, lo.Ternary(release.IsBeta, " (beta)", ""))
+		}
+	}
+	writeChangelogSection(out, "plugin", "plugins", changelog.Plugins)
+	writeChangelogSection(out, "theme", "themes", changelog.Themes)
+}
+
+func writeChangelog(downloadFolder string, changelog Changelog) error {
+	var markdown strings.Builder
+	writeChangelogMarkdown(&markdown, changelog)
+	data, err := json.MarshalIndent(changelog, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	markdownPath := filepath.Join(downloadFolder, WHATS_NEW_FILE)
+	if err := replaceFile(markdownPath, []byte(markdown.String()), 0644); err != nil {
+		return err
+	}
+	return replaceFile(filepath.Join(downloadFolder, WHATS_NEW_JSON_FILE), data, 0644)
+}